@@ -0,0 +1,383 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 断线重连状态回调, Callback可选实现
+type ReconnectCallback interface {
+	// 即将进行第attempt次重连, delay后开始
+	OnReconnecting(attempt int, delay time.Duration)
+	// 重连成功, 在OnConnected之后调用
+	OnReconnected(Conn)
+}
+
+// 客户端公共部分, 负责连接管理和断线重连
+// 具体协议只需实现dial
+type baseClient struct {
+	sync.Mutex
+	impl       Client
+	options    *options
+	serverAddr string
+	callback   Callback
+	conn       Conn
+	// 断线期间缓存的待发送数据
+	queue []pendingFrame
+	// 正在发送缓存的数据, 期间新的数据也进入缓存
+	flushing  bool
+	closed    bool
+	quit      chan struct{}
+	closeOnce sync.Once
+	// 后台处理连接的goroutine是否在运行
+	running bool
+	// 本次运行结束时关闭, err为结束原因
+	done chan struct{}
+	err  error
+}
+
+// 断线期间缓存的一帧数据, 二进制帧为编码后的数据
+type pendingFrame struct {
+	data []byte
+	typ  MessageType
+}
+
+func (c *baseClient) setup(impl Client, callback Callback, o *options) {
+	c.impl = impl
+	c.callback = callback
+	c.options = o
+	c.quit = make(chan struct{})
+}
+
+// 连接服务器, 连接建立后在后台处理消息并返回
+// 开启断线重连时, 连接失败或断开后按重连策略重试, 直到客户端关闭, 重试次数用尽或首次连接时ctx结束
+func (c *baseClient) connect(ctx context.Context, serverAddr string) error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return ConnectionError{"Connect failed: client was closed"}
+	}
+	if c.running {
+		c.Unlock()
+		return ConnectionError{"Connect failed: client is running"}
+	}
+	c.running = true
+	c.serverAddr = serverAddr
+	c.done = make(chan struct{})
+	c.err = nil
+	c.Unlock()
+	conn, err := c.impl.dial(ctx, serverAddr)
+	reconnected := false
+	if err != nil && c.options.reconnect != nil {
+		c.report(nil, "dial", err)
+		conn, err = c.redial(ctx)
+		reconnected = true
+	}
+	if err == nil && !c.attach(conn) {
+		err = ConnectionError{"Connect canceled: client was closed"}
+	}
+	if err != nil {
+		c.finish(err)
+		return err
+	}
+	go c.supervise(conn, reconnected)
+	return nil
+}
+
+// 在后台处理连接, 断开后按重连策略重连, 直到停止运行
+func (c *baseClient) supervise(conn Conn, reconnected bool) {
+	for {
+		err := c.run(conn, reconnected)
+		if c.options.reconnect == nil || c.isClosed() {
+			c.finish(err)
+			return
+		}
+		if conn, err = c.redial(context.Background()); err != nil {
+			c.finish(err)
+			return
+		}
+		if !c.attach(conn) {
+			c.finish(nil)
+			return
+		}
+		reconnected = true
+	}
+}
+
+// 结束本次运行, 主动关闭时不记录错误
+func (c *baseClient) finish(err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		err = nil
+	}
+	c.err = err
+	c.running = false
+	close(c.done)
+}
+
+// 重新连接服务器, 仅在客户端停止运行且未关闭时可用, 连接建立后返回
+func (c *baseClient) Reconnect() error {
+	if c.isClosed() {
+		return ConnectionError{"Reconnect failed: client was closed"}
+	}
+	return c.connect(context.Background(), c.serverAddr)
+}
+
+func (c *baseClient) Done() <-chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	if c.done == nil {
+		// 从未连接过, 视为已停止
+		c.done = make(chan struct{})
+		close(c.done)
+	}
+	return c.done
+}
+
+func (c *baseClient) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+// 按重连策略重新连接, 客户端关闭, ctx结束或重试次数用尽时返回错误
+func (c *baseClient) redial(ctx context.Context) (Conn, error) {
+	policy := c.options.reconnect
+	var lastErr error
+	for attempt := 1; policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts; attempt++ {
+		delay := policy.delay(attempt)
+		if rc, ok := c.callback.(ReconnectCallback); ok {
+			rc.OnReconnecting(attempt, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.quit:
+			timer.Stop()
+			return nil, ConnectionError{"Reconnect canceled: client was closed"}
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		conn, err := c.impl.dial(ctx, c.serverAddr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		c.report(nil, "dial", err)
+	}
+	err := ReconnectFailedError{Attempts: policy.MaxAttempts, LastError: lastErr}
+	c.report(nil, "reconnect", err)
+	return nil, err
+}
+
+// 启用新建立的连接并发送断线期间缓存的数据, 客户端已关闭时关闭连接并返回false
+func (c *baseClient) attach(conn Conn) bool {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		_ = conn.Close()
+		return false
+	}
+	conn.touch()
+	conn.setState(ConnStateConnected)
+	c.options.metrics.ConnOpened(conn.NetProtocol())
+	c.conn = conn
+	c.flushing = len(c.queue) > 0
+	c.Unlock()
+	c.flushQueue(conn)
+	return true
+}
+
+// 处理一个已启用的连接, 直到连接断开, 返回断开原因
+func (c *baseClient) run(conn Conn, reconnected bool) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	if c.options.heartbeatInterval > 0 || c.options.idleTimeout > 0 {
+		go c.heartbeat(conn, stop)
+	}
+	c.options.logger.Log(LevelInfo, "connection opened", connFields(c.options, conn, "")...)
+	if c.callback != nil {
+		c.callback.OnConnected(conn)
+		if rc, ok := c.callback.(ReconnectCallback); ok && reconnected {
+			rc.OnReconnected(conn)
+		}
+	}
+	err := readMessages(conn, c.options, c.callback)
+	conn.setState(ConnStateClosed)
+	c.options.metrics.ConnClosed(conn.NetProtocol())
+	if cerr := conn.Close(); cerr != nil {
+		c.report(conn, "close", cerr)
+	}
+	c.Lock()
+	c.conn = nil
+	c.Unlock()
+	// 主动关闭导致的读取中断不作为错误报告
+	if c.isClosed() {
+		err = nil
+	}
+	c.report(conn, "read", err)
+	c.options.logger.Log(LevelInfo, "connection closed", append(connFields(c.options, conn, ""), "reason", conn.CloseReason())...)
+	if c.callback != nil {
+		c.callback.OnDisconnected(conn)
+	}
+	conn.clearAttributes()
+	return err
+}
+
+// 定期发送心跳, 服务器超过空闲时间无响应时关闭连接
+// 设置了空闲超时时同时检查连接是否长时间没有收发数据
+func (c *baseClient) heartbeat(conn Conn, stop chan struct{}) {
+	heartbeat, idle := newWatchTickers(c.options)
+	defer stopTicker(heartbeat)
+	defer stopTicker(idle)
+	for {
+		select {
+		case <-tickerChan(heartbeat):
+			if checkIdle(conn, c.options) {
+				return
+			}
+			if err := conn.ping(); err != nil {
+				c.report(conn, "heartbeat", err)
+			}
+		case <-tickerChan(idle):
+			if checkIdleTimeout(conn, c.options) {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 发送断线期间缓存的数据, 写入时不持有锁, 期间新的数据继续进入队列以保证顺序
+// 发送失败时保留剩余数据, 等待下次连接
+func (c *baseClient) flushQueue(conn Conn) {
+	for {
+		c.Lock()
+		if c.conn != conn || len(c.queue) == 0 {
+			if c.conn == conn {
+				c.queue = nil
+			}
+			c.flushing = false
+			c.Unlock()
+			return
+		}
+		frame := c.queue[0]
+		c.Unlock()
+		if err := sendFrame(conn, frame); err != nil {
+			c.report(conn, "write", err)
+			c.Lock()
+			c.flushing = false
+			c.Unlock()
+			return
+		}
+		c.Lock()
+		if len(c.queue) > 0 {
+			c.queue[0] = pendingFrame{}
+			c.queue = c.queue[1:]
+		}
+		c.Unlock()
+	}
+}
+
+// 发送一帧数据, 文本帧仅WebSocket连接支持
+func sendFrame(conn Conn, frame pendingFrame) error {
+	if frame.typ == TextMessage {
+		if ws, ok := conn.(WebSocketConn); ok {
+			return ws.SendText(string(frame.data))
+		}
+		return ConnectionError{"Send failed: text frames require a WebSocket connection"}
+	}
+	return conn.Send(frame.data)
+}
+
+// 写入连接时不持有锁, 对端阻塞时不影响Close等其它操作
+func (c *baseClient) send(frame pendingFrame) error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return ConnectionError{"Send failed: client was closed"}
+	}
+	if conn := c.conn; conn != nil && conn.State() == ConnStateConnected && !c.flushing {
+		c.Unlock()
+		return sendFrame(conn, frame)
+	}
+	defer c.Unlock()
+	if c.options.sendQueueSize > 0 {
+		if len(frame.data) == 0 {
+			return EmptyMessageError{}
+		}
+		if len(c.queue) >= c.options.sendQueueSize {
+			return ConnectionError{"Send failed: send queue is full"}
+		}
+		c.queue = append(c.queue, frame)
+		return nil
+	}
+	return ConnectionError{"Send failed: connection was not built"}
+}
+
+func (c *baseClient) Send(msg []byte) error {
+	return c.send(pendingFrame{data: msg})
+}
+
+// 文本消息不经过编解码器, 以文本帧发送, 非WebSocket连接返回错误
+func (c *baseClient) SendMessage(msg *Message) error {
+	if msg == nil {
+		return EmptyMessageError{}
+	}
+	if msg.Type == TextMessage {
+		if c.options.protocol != WebSocket {
+			return ConnectionError{"Send failed: text frames require a WebSocket connection"}
+		}
+		return c.send(pendingFrame{data: msg.Payload, typ: TextMessage})
+	}
+	data, err := c.options.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+// 关闭客户端, 停止重连并丢弃未发送的数据
+func (c *baseClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+	c.Lock()
+	c.closed = true
+	c.queue = nil
+	conn := c.conn
+	c.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (c *baseClient) isClosed() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.closed
+}
+
+// 报告错误, conn为产生错误的连接, 没有时为nil
+func (c *baseClient) report(conn Conn, phase string, err error) {
+	reportError(c.options, c.callback, conn, phase, err)
+}
@@ -0,0 +1,300 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+type reconnectCallback struct {
+	testCallback
+	reconnecting []int
+	reconnected  int
+}
+
+func (c *reconnectCallback) OnReconnecting(attempt int, delay time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.reconnecting = append(c.reconnecting, attempt)
+}
+
+func (c *reconnectCallback) OnReconnected(conn Conn) {
+	c.Lock()
+	defer c.Unlock()
+	c.reconnected++
+}
+
+func newTestClient(t *testing.T, protocol Protocol, callback Callback, opts ...Option) Client {
+	var client Client
+	switch protocol {
+	case Tcp:
+		client = &tcpClient{}
+	case WebSocket:
+		client = &wsClient{}
+	case Kcp:
+		client = &kcpClient{}
+	}
+	o, err := newOptions(protocol, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.setup(client, callback, o)
+	return client
+}
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	p := ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := p.delay(i + 1); d != w*time.Millisecond {
+			t.Fatal(i+1, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatal(d)
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr()
+
+	cb := &reconnectCallback{}
+	client := newTestClient(t, Tcp, cb,
+		WithReconnect(ReconnectPolicy{InitialDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond}),
+		WithSendQueue(10))
+	if err := client.connect(context.Background(), addr); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+
+	server.Close()
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := cb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("not disconnected")
+	}
+	// 断线期间发送的数据进入队列
+	if err := client.SendMessage(&Message{Id: 7, Payload: []byte("queued")}); err != nil {
+		t.Fatal(err)
+	}
+	server, err = Listen(Tcp, mustPort(t, addr), serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if !waitFor(2*time.Second, func() bool {
+		cb.Lock()
+		defer cb.Unlock()
+		return cb.reconnected == 1
+	}) {
+		t.Fatal("not reconnected")
+	}
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := serverCb.count()
+		return messages == 1
+	}) {
+		t.Fatal("queued message not flushed")
+	}
+	serverCb.Lock()
+	msg := serverCb.messages[0]
+	serverCb.Unlock()
+	if msg.Id != 7 || string(msg.Payload) != "queued" {
+		t.Fatal(msg)
+	}
+	cb.Lock()
+	if len(cb.reconnecting) == 0 || cb.reconnecting[0] != 1 {
+		t.Error(cb.reconnecting)
+	}
+	cb.Unlock()
+
+	client.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client not done")
+	}
+	if err := client.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientReconnectGiveUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	cb := &reconnectCallback{}
+	client := newTestClient(t, Tcp, cb,
+		WithReconnect(ReconnectPolicy{InitialDelay: time.Millisecond, MaxAttempts: 3}))
+	err = client.connect(context.Background(), addr)
+	if e, ok := err.(ReconnectFailedError); !ok || e.Attempts != 3 {
+		t.Fatal(err)
+	}
+	if len(cb.reconnecting) != 3 {
+		t.Fatal(cb.reconnecting)
+	}
+	if _, ok := client.Err().(ReconnectFailedError); !ok {
+		t.Fatal(client.Err())
+	}
+}
+
+func TestConnectReturnsWhileConnected(t *testing.T) {
+	server, err := Listen(Tcp, 0, echoCallback(), WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &testCallback{}
+	client, err := Connect(Tcp, server.Addr(), cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMessage(&Message{Id: 1, Payload: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("no echo")
+	}
+	select {
+	case <-client.Done():
+		t.Fatal("client done while connected")
+	default:
+	}
+	server.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client not done after server closed")
+	}
+	if client.Err() == nil {
+		t.Fatal("missing termination error")
+	}
+	if err := client.Reconnect(); err == nil {
+		t.Fatal("reconnected to closed server")
+	}
+	client.Close()
+	if err := client.Reconnect(); err == nil {
+		t.Fatal("reconnected closed client")
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ConnectContext(ctx, Kcp, "127.0.0.1:1", nil); err != context.Canceled {
+		t.Fatal(err)
+	}
+	// 接受连接但不响应TLS握手的服务器
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = ConnectContext(ctx, Tcp, listener.Addr().String(), nil, WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("connect timeout ignored", elapsed)
+	}
+}
+
+func TestClientSendWithoutQueue(t *testing.T) {
+	client := newTestClient(t, Tcp, nil)
+	if err := client.Send([]byte("data")); err == nil {
+		t.Fail()
+	}
+}
+
+func TestClientCloseWhileSendBlocked(t *testing.T) {
+	// 对端接受连接后不读取数据, 写入最终会阻塞
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client, err := Connect(Tcp, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		data := make([]byte, 1<<20)
+		for client.Send(data) == nil {
+		}
+	}()
+	select {
+	case <-sendDone:
+		t.Fatal("send did not block")
+	case <-time.After(200 * time.Millisecond):
+	}
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by a stalled send")
+	}
+	select {
+	case <-sendDone:
+	case <-time.After(time.Second):
+		t.Fatal("blocked send not released by Close")
+	}
+}
+
+func mustPort(t *testing.T, addr string) int {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := net.LookupPort("tcp", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// 消息编解码器, 决定消息在网络上的传输格式
+type Codec interface {
+	// 将消息编码为字节流
+	Encode(msg *Message) ([]byte, error)
+	// 解码一个自带边界的完整数据帧(如WebSocket消息)
+	Decode(frame []byte) (*Message, error)
+	// 流式解码, 从buffer头部解出一条完整消息, 返回消息和剩余数据
+	// 数据不足一条消息时返回nil消息和原buffer, 等待后续数据
+	DecodeStream(buffer []byte) (*Message, []byte, error)
+}
+
+// 可在消息完整到达前得知消息长度的编解码器, 配合WithMaxMessageSize在读取消息体前拒绝超长消息
+// 未实现该接口的编解码器只能在缓存数据超过限制后拒绝
+type MessageSizer interface {
+	// buffer头部消息编码后的总长度, 包含长度字段; 长度字段不完整时返回0
+	MessageSize(buffer []byte) (int, error)
+}
+
+// 默认编解码器
+func defaultCodec() Codec {
+	return NewLengthCodec()
+}
+
+// 定长包头编解码器
+// |--- message length ---|--- message id ---|--- message payload ---|
+// |---     4 bytes    ---|---   4 bytes  ---|---      n bytes    ---|
+type lengthCodec struct {
+}
+
+func NewLengthCodec() Codec {
+	return &lengthCodec{}
+}
+
+func (c *lengthCodec) Encode(msg *Message) ([]byte, error) {
+	bodyLen := len(msg.Payload)
+	if bodyLen > math.MaxInt32-4 {
+		return nil, InvalidMessageLengthError{Length: bodyLen}
+	}
+	pk := make([]byte, 8+bodyLen)
+	// 写入长度, 包含消息id的4个字节
+	binary.BigEndian.PutUint32(pk[:4], uint32(bodyLen+4))
+	// 写入id
+	binary.BigEndian.PutUint32(pk[4:8], uint32(msg.Id))
+	// 消息体
+	copy(pk[8:], msg.Payload)
+	return pk, nil
+}
+
+func (c *lengthCodec) Decode(frame []byte) (*Message, error) {
+	return decodeFrame(c, frame)
+}
+
+func (c *lengthCodec) DecodeStream(buffer []byte) (*Message, []byte, error) {
+	if len(buffer) < 4 {
+		return nil, buffer, nil
+	}
+	// 前4个字节为包长度
+	length := int(int32(binary.BigEndian.Uint32(buffer[:4])))
+	if length < 4 {
+		return nil, buffer, InvalidMessageLengthError{Length: length}
+	}
+	if len(buffer)-4 < length {
+		return nil, buffer, nil
+	}
+	// 再4个字节为消息ID
+	id := int32(binary.BigEndian.Uint32(buffer[4:8]))
+	// 剩余为包体
+	return &Message{Id: id, Payload: buffer[8 : 4+length]}, remainBuffer(buffer, 4+length), nil
+}
+
+func (c *lengthCodec) MessageSize(buffer []byte) (int, error) {
+	if len(buffer) < 4 {
+		return 0, nil
+	}
+	length := int(int32(binary.BigEndian.Uint32(buffer[:4])))
+	if length < 4 {
+		return 0, InvalidMessageLengthError{Length: length}
+	}
+	return 4 + length, nil
+}
+
+// 变长包头编解码器, 长度和消息id均采用varint编码, 适合小包较多的场景
+// |--- message length ---|--- message id ---|--- message payload ---|
+// |---  uvarint 1~5 B ---|--- varint 1~5 B---|---      n bytes    ---|
+type varintCodec struct {
+}
+
+func NewVarintCodec() Codec {
+	return &varintCodec{}
+}
+
+func (c *varintCodec) Encode(msg *Message) ([]byte, error) {
+	var id [binary.MaxVarintLen32]byte
+	idLen := binary.PutVarint(id[:], int64(msg.Id))
+	bodyLen := idLen + len(msg.Payload)
+	if bodyLen > math.MaxInt32 {
+		return nil, InvalidMessageLengthError{Length: len(msg.Payload)}
+	}
+	pk := make([]byte, binary.MaxVarintLen32+bodyLen)
+	n := binary.PutUvarint(pk, uint64(bodyLen))
+	n += copy(pk[n:], id[:idLen])
+	n += copy(pk[n:], msg.Payload)
+	return pk[:n], nil
+}
+
+func (c *varintCodec) Decode(frame []byte) (*Message, error) {
+	return decodeFrame(c, frame)
+}
+
+func (c *varintCodec) DecodeStream(buffer []byte) (*Message, []byte, error) {
+	length, n := binary.Uvarint(buffer)
+	if n == 0 {
+		// 长度字段尚未完整
+		if len(buffer) < binary.MaxVarintLen32 {
+			return nil, buffer, nil
+		}
+		return nil, buffer, InvalidMessageError{"malformed message length"}
+	}
+	if n < 0 || n > binary.MaxVarintLen32 || length > math.MaxInt32 {
+		return nil, buffer, InvalidMessageError{"malformed message length"}
+	}
+	if uint64(len(buffer)-n) < length {
+		return nil, buffer, nil
+	}
+	body := buffer[n : n+int(length)]
+	id, m := binary.Varint(body)
+	if m <= 0 || id < math.MinInt32 || id > math.MaxInt32 {
+		return nil, buffer, InvalidMessageError{"malformed message id"}
+	}
+	return &Message{Id: int32(id), Payload: body[m:]}, remainBuffer(buffer, n+int(length)), nil
+}
+
+func (c *varintCodec) MessageSize(buffer []byte) (int, error) {
+	length, n := binary.Uvarint(buffer)
+	if n == 0 {
+		if len(buffer) < binary.MaxVarintLen32 {
+			return 0, nil
+		}
+		return 0, InvalidMessageError{"malformed message length"}
+	}
+	if n < 0 || n > binary.MaxVarintLen32 || length > math.MaxInt32 {
+		return 0, InvalidMessageError{"malformed message length"}
+	}
+	return n + int(length), nil
+}
+
+// 透传编解码器, 不做任何封装, 消息id恒为0
+// 用于流式连接时, 每次读取到的数据作为一条消息
+type rawCodec struct {
+}
+
+func NewRawCodec() Codec {
+	return &rawCodec{}
+}
+
+func (c *rawCodec) Encode(msg *Message) ([]byte, error) {
+	return msg.Payload, nil
+}
+
+func (c *rawCodec) Decode(frame []byte) (*Message, error) {
+	return &Message{Payload: frame}, nil
+}
+
+func (c *rawCodec) DecodeStream(buffer []byte) (*Message, []byte, error) {
+	if len(buffer) == 0 {
+		return nil, buffer, nil
+	}
+	return &Message{Payload: buffer}, make([]byte, 0), nil
+}
+
+// 使用流式解码实现完整帧解码, 帧内必须恰好是一条消息
+func decodeFrame(codec Codec, frame []byte) (*Message, error) {
+	msg, remain, err := codec.DecodeStream(frame)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, InvalidMessageError{"incomplete message"}
+	}
+	if len(remain) > 0 {
+		return nil, InvalidMessageError{"redundant data after message"}
+	}
+	return msg, nil
+}
+
+// 截取已解码消息之后的剩余数据
+// 剩余数据与已交付的消息不重叠, 后续追加不会覆盖消息内容
+func remainBuffer(buffer []byte, n int) []byte {
+	if n < len(buffer) {
+		return buffer[n:]
+	}
+	return make([]byte, 0)
+}
+
+// 将流式数据拆分为完整消息, 逐条回调, 返回不足一条消息的剩余数据
+// maxSize大于0时, 超过该长度的消息返回InvalidMessageLengthError
+func splitStream(codec Codec, buffer []byte, maxSize int, fn func(*Message)) ([]byte, error) {
+	sizer, _ := codec.(MessageSizer)
+	for {
+		if maxSize > 0 && sizer != nil {
+			size, err := sizer.MessageSize(buffer)
+			if err != nil {
+				return buffer, err
+			}
+			if size > maxSize {
+				return buffer, InvalidMessageLengthError{Length: size, Limit: maxSize}
+			}
+		}
+		msg, remain, err := codec.DecodeStream(buffer)
+		if err != nil {
+			return remain, err
+		}
+		buffer = remain
+		if msg == nil {
+			if maxSize > 0 && len(buffer) > maxSize {
+				// 无法提前得知长度的编解码器, 缓存的不完整数据超过限制
+				return buffer, InvalidMessageLengthError{Length: len(buffer), Limit: maxSize}
+			}
+			return buffer, nil
+		}
+		fn(msg)
+	}
+}
@@ -0,0 +1,244 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestLengthCodec(t *testing.T) {
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: 128, Payload: []byte("test_packer")})
+	msg, err := c.Decode(out)
+	if err != nil || msg.Id != 128 || string(msg.Payload) != "test_packer" {
+		fmt.Println(msg, err)
+		t.Fail()
+	}
+}
+
+func TestLengthCodec1(t *testing.T) {
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: -123, Payload: []byte("中文测试")})
+	msg, err := c.Decode(out)
+	if err != nil || msg.Id != -123 || string(msg.Payload) != "中文测试" {
+		fmt.Println(msg, err)
+		t.Fail()
+	}
+}
+
+func TestLengthCodec2(t *testing.T) {
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: -123, Payload: []byte(`{"a":"a", "b":1.1}`)})
+	msg, err := c.Decode(out)
+	if err != nil || msg.Id != -123 || string(msg.Payload) != `{"a":"a", "b":1.1}` {
+		fmt.Println(msg, err)
+		t.Fail()
+	}
+}
+
+func TestLengthCodec3(t *testing.T) {
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: -123, Payload: []byte(`{"a":"a", "b":1.1}`)})
+	out = append(out, []byte("[append]")...)
+	msg, out, _ := c.DecodeStream(out)
+	if msg == nil || msg.Id != -123 || string(msg.Payload) != `{"a":"a", "b":1.1}` || string(out) != "[append]" {
+		fmt.Println(msg)
+		t.Fail()
+	}
+}
+
+func TestLengthCodecInvalidLength(t *testing.T) {
+	c := NewLengthCodec()
+	if _, _, err := c.DecodeStream([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestVarintCodec(t *testing.T) {
+	c := NewVarintCodec()
+	for _, id := range []int32{0, 1, -1, 300, -123456, 1<<31 - 1, -1 << 31} {
+		out, _ := c.Encode(&Message{Id: id, Payload: []byte("varint")})
+		msg, err := c.Decode(out)
+		if err != nil || msg.Id != id || string(msg.Payload) != "varint" {
+			fmt.Println(id, msg, err)
+			t.Fail()
+		}
+	}
+	out, _ := c.Encode(&Message{Id: 1, Payload: nil})
+	if len(out) != 2 {
+		t.Fatal(out)
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	c := NewRawCodec()
+	out, _ := c.Encode(&Message{Id: 1, Payload: []byte("raw")})
+	if string(out) != "raw" {
+		t.Fatal(out)
+	}
+	msg, remain, err := c.DecodeStream(out)
+	if err != nil || msg.Id != 0 || string(msg.Payload) != "raw" || len(remain) != 0 {
+		t.Fail()
+	}
+	if msg, _, _ := c.DecodeStream(nil); msg != nil {
+		t.Fail()
+	}
+}
+
+func TestSplitStream(t *testing.T) {
+	for _, c := range []Codec{NewLengthCodec(), NewVarintCodec()} {
+		var stream []byte
+		for i := 0; i < 3; i++ {
+			out, _ := c.Encode(&Message{Id: int32(i), Payload: []byte("payload_" + strconv.Itoa(i))})
+			stream = append(stream, out...)
+		}
+		// 按字节逐个投递, 模拟任意的读取边界
+		var msgs []*Message
+		var buffer []byte
+		for _, b := range stream {
+			var err error
+			buffer, err = splitStream(c, append(buffer, b), 0, func(msg *Message) {
+				msgs = append(msgs, msg)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(msgs) != 3 || len(buffer) != 0 {
+			t.Fatalf("got %d messages, %d bytes left", len(msgs), len(buffer))
+		}
+		for i, msg := range msgs {
+			if msg.Id != int32(i) || string(msg.Payload) != "payload_"+strconv.Itoa(i) {
+				fmt.Println(msg)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSplitStreamPartial(t *testing.T) {
+	c := NewLengthCodec()
+	first, _ := c.Encode(&Message{Id: 1, Payload: []byte("first")})
+	second, _ := c.Encode(&Message{Id: 2, Payload: []byte("second")})
+	n := 0
+	buffer, err := splitStream(c, append(first, second[:5]...), 0, func(msg *Message) {
+		n++
+	})
+	if err != nil || n != 1 || string(buffer) != string(second[:5]) {
+		t.Fatal(n, buffer, err)
+	}
+	buffer, err = splitStream(c, append(buffer, second[5:]...), 0, func(msg *Message) {
+		n++
+		if msg.Id != 2 || string(msg.Payload) != "second" {
+			t.Fail()
+		}
+	})
+	if err != nil || n != 2 || len(buffer) != 0 {
+		t.Fatal(n, buffer, err)
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: 1, Payload: []byte("frame")})
+	if _, err := c.Decode(out[:len(out)-1]); err == nil {
+		t.Fail()
+	}
+	if _, err := c.Decode(append(out, 0)); err == nil {
+		t.Fail()
+	}
+}
+
+func BenchmarkLengthCodec_Encode(b *testing.B) {
+	b.StopTimer()
+	c := NewLengthCodec()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		c.Encode(&Message{Id: 123, Payload: []byte(`{"a":"a", "b":1.1}`)})
+	}
+}
+
+func BenchmarkLengthCodec_Encode1(b *testing.B) {
+	b.StopTimer()
+	c := NewLengthCodec()
+	m := make(map[string]interface{})
+	for i := 0; i < 10000; i++ {
+		m[strconv.FormatInt(int64(i), 10)] = i
+	}
+	bytes, _ := json.Marshal(m)
+	fmt.Println(len(bytes))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		c.Encode(&Message{Id: 123, Payload: bytes})
+	}
+}
+
+func BenchmarkLengthCodec_Decode(b *testing.B) {
+	b.StopTimer()
+	c := NewLengthCodec()
+	out, _ := c.Encode(&Message{Id: -123, Payload: []byte(`{"a":"a", "b":1.1}`)})
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		c.Decode(out)
+	}
+}
+
+func BenchmarkVarintCodec_Decode(b *testing.B) {
+	b.StopTimer()
+	c := NewVarintCodec()
+	out, _ := c.Encode(&Message{Id: -123, Payload: []byte(`{"a":"a", "b":1.1}`)})
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		c.Decode(out)
+	}
+}
+
+func TestSplitStreamMaxSize(t *testing.T) {
+	for _, c := range []Codec{NewLengthCodec(), NewVarintCodec()} {
+		small, _ := c.Encode(&Message{Id: 1, Payload: []byte("small")})
+		large, _ := c.Encode(&Message{Id: 2, Payload: make([]byte, 1024)})
+		var received []*Message
+		// 超长消息只需收到长度字段即可拒绝
+		_, err := splitStream(c, append(small, large[:5]...), 100, func(msg *Message) {
+			received = append(received, msg)
+		})
+		if len(received) != 1 || received[0].Id != 1 {
+			t.Fatal(received)
+		}
+		e, ok := err.(InvalidMessageLengthError)
+		if !ok || e.Length != len(large) || e.Limit != 100 {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 不实现MessageSizer的编解码器, 按行分隔消息
+type lineCodec struct{}
+
+func (c *lineCodec) Encode(msg *Message) ([]byte, error) {
+	return append(msg.Payload, '\n'), nil
+}
+
+func (c *lineCodec) Decode(frame []byte) (*Message, error) {
+	return decodeFrame(c, frame)
+}
+
+func (c *lineCodec) DecodeStream(buffer []byte) (*Message, []byte, error) {
+	for i, b := range buffer {
+		if b == '\n' {
+			return &Message{Payload: buffer[:i]}, remainBuffer(buffer, i+1), nil
+		}
+	}
+	return nil, buffer, nil
+}
+
+func TestSplitStreamMaxSizeWithoutSizer(t *testing.T) {
+	buffer, err := splitStream(&lineCodec{}, []byte("short\nunterminated"), 16, func(*Message) {})
+	if err != nil || string(buffer) != "unterminated" {
+		t.Fatal(string(buffer), err)
+	}
+	if _, err := splitStream(&lineCodec{}, append(buffer, "-overflow"...), 16, func(*Message) {}); err == nil {
+		t.Fatal("buffer grows without limit")
+	}
+}
@@ -15,20 +15,61 @@
 package net
 
 import (
+	"crypto/x509"
 	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 网络连接接口
 type Conn interface {
 	Send(msg []byte) error
+	SendMessage(msg *Message) error
+	// 异步发送消息, 返回的通道在发送完成或失败后收到结果
+	SendAsync(msg *Message) <-chan error
+	writeFrame(typ MessageType, data []byte) error
 	Close() error
 	RemoteAddr() string
 	LocalAddr() string
 	read(*[]byte) (int, error)
+	// 读写截止时间, 与WithReadTimeout/WithWriteTimeout同时使用时以较早者为准
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetDeadline(t time.Time) error
+	setReadDeadline(t time.Time) error
+	setWriteDeadline(t time.Time) error
 	NetProtocol() Protocol
 	Identity() uint32
 	State() ConnState
 	setState(ConnState)
+	// 连接关闭的原因, 如心跳超时; 连接未关闭或原因未知时为nil
+	CloseReason() error
+	setCloseReason(error)
+	// 最后一次收到数据的时间
+	lastActive() time.Time
+	touch()
+	// 最后一次发送数据的时间
+	lastWritten() time.Time
+	touchWrite()
+	ping() error
+	// 发送通知类消息, 写队列已满时直接放弃, 不阻塞调用者
+	notify(msg *Message) error
+	isClosed() bool
+	stopRead()
+	// 对端证书链, 非TLS连接为nil
+	PeerCertificates() []*x509.Certificate
+	handshake(timeout time.Duration) error
+	// 连接属性, 并发安全, 在OnDisconnected之后自动清空
+	Set(key, value interface{})
+	Get(key interface{}) (interface{}, bool)
+	Delete(key interface{})
+	clearAttributes()
+	// 入站限速, 默认为WithRateLimit的配置
+	SetRateLimit(RateLimit) error
+	RateLimit() RateLimit
+	admit(size int) bool
 }
 
 type ConnState int
@@ -49,14 +90,64 @@ func init() {
 }
 
 type baseConn struct {
-	identity uint32
-	state    ConnState
+	impl        Conn
+	identity    uint32
+	state       int32
+	closed      int32
+	active      int64
+	written     int64
+	codec       Codec
+	reasonMutex sync.Mutex
+	reason      error
+	attrMutex   sync.RWMutex
+	attrs       map[interface{}]interface{}
+	writer      connWriter
+	// 超时设置及用户设置的截止时间
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	limiter       rateLimiter
+	// 停止读取时关闭, 中断读取协程中的限速等待
+	readStop     chan struct{}
+	readStopOnce sync.Once
+}
+
+// 初始化连接, 由各协议的连接构造时调用
+func (c *baseConn) setup(impl Conn, o *options) {
+	c.impl = impl
+	c.readStop = make(chan struct{})
+	c.codec = o.codec
+	c.readTimeout = o.readTimeout
+	c.writeTimeout = o.writeTimeout
+	c.limiter.set(o.rateLimit)
+	c.initWriter(impl, o)
 }
 
 func (c *baseConn) Send(msg []byte) error {
 	return errors.New("not implements: send")
 }
 
+func (c *baseConn) SendMessage(msg *Message) error {
+	return errors.New("not implements: send message")
+}
+
+func (c *baseConn) writeFrame(MessageType, []byte) error {
+	return errors.New("not implements: write frame")
+}
+
+// 使用连接的编解码器编码消息
+func (c *baseConn) encode(msg *Message) ([]byte, error) {
+	if msg == nil {
+		return nil, EmptyMessageError{}
+	}
+	if c.codec == nil {
+		c.codec = defaultCodec()
+	}
+	return c.codec.Encode(msg)
+}
+
 func (c *baseConn) Close() {
 }
 
@@ -72,21 +163,376 @@ func (c *baseConn) read(*[]byte) (int, error) {
 	return -1, errors.New("not implements: read")
 }
 
+func (c *baseConn) SetReadDeadline(t time.Time) error {
+	if c.impl == nil {
+		return errors.New("not implements: set read deadline")
+	}
+	c.deadlineMutex.Lock()
+	c.readDeadline = t
+	c.deadlineMutex.Unlock()
+	return c.impl.setReadDeadline(t)
+}
+
+func (c *baseConn) SetWriteDeadline(t time.Time) error {
+	if c.impl == nil {
+		return errors.New("not implements: set write deadline")
+	}
+	c.deadlineMutex.Lock()
+	c.writeDeadline = t
+	c.deadlineMutex.Unlock()
+	return c.impl.setWriteDeadline(t)
+}
+
+func (c *baseConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *baseConn) setReadDeadline(time.Time) error {
+	return errors.New("not implements: set read deadline")
+}
+
+func (c *baseConn) setWriteDeadline(time.Time) error {
+	return errors.New("not implements: set write deadline")
+}
+
+// 每次读取前按读超时设置截止时间, 读取已停止时不再修改
+func (c *baseConn) prepareRead() {
+	if c.readTimeout > 0 {
+		c.deadlineMutex.Lock()
+		defer c.deadlineMutex.Unlock()
+		if c.readStopped() {
+			return
+		}
+		_ = c.impl.setReadDeadline(earlier(c.readDeadline, time.Now().Add(c.readTimeout)))
+	}
+}
+
+// 停止读取, 使阻塞中的读取和限速等待立即返回
+// 用于关闭服务器及关闭时写队列尚未发送完成的连接
+func (c *baseConn) stopRead() {
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	c.interruptRead()
+	_ = c.impl.setReadDeadline(time.Now())
+}
+
+func (c *baseConn) interruptRead() {
+	c.readStopOnce.Do(func() {
+		if c.readStop != nil {
+			close(c.readStop)
+		}
+	})
+}
+
+func (c *baseConn) readStopped() bool {
+	select {
+	case <-c.readStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 每次写入前按写超时设置截止时间
+func (c *baseConn) prepareWrite() {
+	if c.writeTimeout > 0 {
+		c.deadlineMutex.Lock()
+		deadline := earlier(c.writeDeadline, time.Now().Add(c.writeTimeout))
+		c.deadlineMutex.Unlock()
+		_ = c.impl.setWriteDeadline(deadline)
+	}
+}
+
+// 读写超时转换为TimeoutError
+func (c *baseConn) timeoutError(op string, err error) error {
+	if !isTimeout(err) {
+		return err
+	}
+	c.deadlineMutex.Lock()
+	timeout, deadline := c.readTimeout, c.readDeadline
+	if op == "write" {
+		timeout, deadline = c.writeTimeout, c.writeDeadline
+	}
+	c.deadlineMutex.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		// 用户设置的截止时间先到达
+		timeout = 0
+	}
+	return TimeoutError{Op: op, Timeout: timeout}
+}
+
+// 用户设置的截止时间为零值时不生效
+func earlier(deadline, timeout time.Time) time.Time {
+	if !deadline.IsZero() && deadline.Before(timeout) {
+		return deadline
+	}
+	return timeout
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
 func (c *baseConn) NetProtocol() Protocol {
 	return -1
 }
 
 func (c *baseConn) Identity() uint32 {
-	if !identifier.IsValidIdentity(c.identity) {
-		c.identity = identifier.GenIdentity()
+	if id := atomic.LoadUint32(&c.identity); identifier.IsValidIdentity(id) {
+		return id
 	}
-	return c.identity
+	atomic.CompareAndSwapUint32(&c.identity, 0, identifier.GenIdentity())
+	return atomic.LoadUint32(&c.identity)
 }
 
 func (c *baseConn) State() ConnState {
-	return c.state
+	return ConnState(atomic.LoadInt32(&c.state))
 }
 
 func (c *baseConn) setState(state ConnState) {
-	c.state = state
+	atomic.StoreInt32(&c.state, int32(state))
+}
+
+func (c *baseConn) CloseReason() error {
+	c.reasonMutex.Lock()
+	defer c.reasonMutex.Unlock()
+	return c.reason
+}
+
+// 记录关闭原因, 仅保留第一次设置的原因
+func (c *baseConn) setCloseReason(err error) {
+	c.reasonMutex.Lock()
+	defer c.reasonMutex.Unlock()
+	if c.reason == nil {
+		c.reason = err
+	}
+}
+
+func (c *baseConn) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.active))
+}
+
+func (c *baseConn) touch() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+}
+
+func (c *baseConn) lastWritten() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.written))
+}
+
+func (c *baseConn) touchWrite() {
+	atomic.StoreInt64(&c.written, time.Now().UnixNano())
+}
+
+func (c *baseConn) PeerCertificates() []*x509.Certificate {
+	return nil
+}
+
+// 服务器接收连接后的握手, 无需握手的连接直接返回
+func (c *baseConn) handshake(timeout time.Duration) error {
+	return nil
+}
+
+// 发送心跳请求
+func (c *baseConn) ping() error {
+	return errors.New("not implements: ping")
+}
+
+func (c *baseConn) Set(key, value interface{}) {
+	c.attrMutex.Lock()
+	defer c.attrMutex.Unlock()
+	if c.attrs == nil {
+		c.attrs = make(map[interface{}]interface{})
+	}
+	c.attrs[key] = value
+}
+
+func (c *baseConn) Get(key interface{}) (interface{}, bool) {
+	c.attrMutex.RLock()
+	defer c.attrMutex.RUnlock()
+	value, ok := c.attrs[key]
+	return value, ok
+}
+
+func (c *baseConn) Delete(key interface{}) {
+	c.attrMutex.Lock()
+	defer c.attrMutex.Unlock()
+	delete(c.attrs, key)
+}
+
+func (c *baseConn) clearAttributes() {
+	c.attrMutex.Lock()
+	defer c.attrMutex.Unlock()
+	c.attrs = nil
+}
+
+// 标记连接已关闭, 仅第一次调用返回true
+func (c *baseConn) markClosed() bool {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return false
+	}
+	c.interruptRead()
+	return true
+}
+
+func (c *baseConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// 读取连接数据并拆分为消息逐条回调, 直到读取出错
+// 连接设置了关闭原因时, 返回关闭原因而非读取错误
+func readMessages(conn Conn, o *options, callback Callback) error {
+	err := readConn(conn, o, callback)
+	if reason := conn.CloseReason(); reason != nil {
+		return reason
+	}
+	return err
+}
+
+// 关闭连接并记录原因
+func closeWithReason(conn Conn, reason error) error {
+	conn.setCloseReason(reason)
+	return conn.Close()
+}
+
+// 踢出连接, 尽力将原因通知对端后关闭
+// WebSocket发送关闭帧, 其它协议发送保留的踢出消息, 使用不支持消息id的编解码器时直接关闭
+func kickConn(conn Conn, codec Codec, reason string) error {
+	kicked := KickedError{Reason: reason}
+	conn.setCloseReason(kicked)
+	if ws, ok := conn.(WebSocketConn); ok {
+		return ws.CloseWithCode(ClosePolicyViolation, reason)
+	}
+	if _, raw := codec.(*rawCodec); !raw && conn.State() == ConnStateConnected {
+		_ = conn.notify(&Message{Id: MessageIdKick, Payload: []byte(reason)})
+	}
+	return conn.Close()
+}
+
+func readConn(conn Conn, o *options, callback Callback) error {
+	if conn.NetProtocol() == WebSocket {
+		return readFrames(conn, o, callback)
+	}
+	return readStream(conn, o, callback)
+}
+
+// 流式连接(TCP/KCP), 按编解码器格式拆分, 不完整的数据保留到下次读取
+func readStream(conn Conn, o *options, callback Callback) error {
+	buf := make([]byte, o.readBufferSize)
+	byteBuffer := make([]byte, 0)
+	for {
+		l, err := conn.read(&buf)
+		if err != nil {
+			return err
+		}
+		conn.touch()
+		o.metrics.BytesReceived(conn.NetProtocol(), l)
+		byteBuffer = append(byteBuffer, buf[:l]...)
+		byteBuffer, err = splitStream(o.codec, byteBuffer, o.maxMessageSize, func(msg *Message) {
+			// 连接已关闭时丢弃剩余的消息
+			if !conn.isClosed() {
+				dispatch(conn, msg, o, callback)
+			}
+		})
+		if err != nil {
+			o.metrics.DecodeFailed(conn.NetProtocol())
+			return err
+		}
+	}
+}
+
+// 按帧读取的连接
+type frameReader interface {
+	readFrame() (MessageType, []byte, error)
+}
+
+// 帧连接(WebSocket), 每帧为一条完整消息
+// 二进制帧由编解码器解码, 文本帧原样交给回调
+func readFrames(conn Conn, o *options, callback Callback) error {
+	reader, ok := conn.(frameReader)
+	if !ok {
+		return ConnectionError{"Read failed, connection does not support frames"}
+	}
+	for {
+		t, data, err := reader.readFrame()
+		if err != nil {
+			return err
+		}
+		conn.touch()
+		o.metrics.BytesReceived(conn.NetProtocol(), len(data))
+		if t == TextMessage {
+			dispatch(conn, &Message{Payload: data, Type: TextMessage}, o, callback)
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		msg, err := o.codec.Decode(data)
+		if err != nil {
+			o.metrics.DecodeFailed(conn.NetProtocol())
+			return err
+		}
+		dispatch(conn, msg, o, callback)
+	}
+}
+
+// 心跳检查, 连接超过空闲时间未收到任何数据时关闭并返回true
+func checkIdle(conn Conn, o *options) bool {
+	window := o.heartbeatInterval * time.Duration(o.heartbeatMaxMissed)
+	if idle := time.Since(conn.lastActive()); idle > window {
+		_ = closeWithReason(conn, HeartbeatTimeoutError{Idle: idle})
+		return true
+	}
+	return false
+}
+
+// 空闲超时检查, 连接超过空闲超时没有收发任何数据时关闭并返回true
+func checkIdleTimeout(conn Conn, o *options) bool {
+	last := conn.lastActive()
+	if written := conn.lastWritten(); written.After(last) {
+		last = written
+	}
+	if time.Since(last) > o.idleTimeout {
+		_ = closeWithReason(conn, TimeoutError{Op: "idle", Timeout: o.idleTimeout})
+		return true
+	}
+	return false
+}
+
+// 心跳和空闲超时的检查定时器, 未开启的为nil
+func newWatchTickers(o *options) (heartbeat, idle *time.Ticker) {
+	if o.heartbeatInterval > 0 {
+		heartbeat = time.NewTicker(o.heartbeatInterval)
+	}
+	if o.idleTimeout > 0 {
+		idle = time.NewTicker(idleCheckInterval(o.idleTimeout))
+	}
+	return
+}
+
+// 空闲超时的检查间隔, 检查误差不超过超时时间的一半
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	return interval
+}
+
+// nil定时器返回nil通道, 在select中永远不会就绪
+func tickerChan(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func stopTicker(t *time.Ticker) {
+	if t != nil {
+		t.Stop()
+	}
 }
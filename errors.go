@@ -14,7 +14,10 @@
 
 package net
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type UnknownNetTypeError struct {
 	UnknownType int
@@ -24,11 +27,20 @@ func (e UnknownNetTypeError) Error() string {
 	return fmt.Sprintf("unknown net type error: %d", e.UnknownType)
 }
 
+// 消息长度非法, 或超过WithMaxMessageSize设置的限制
+// 超过限制时Limit为限制值; WebSocket无法得知超长消息的实际长度, Length为0
 type InvalidMessageLengthError struct {
 	Length int
+	Limit  int
 }
 
 func (e InvalidMessageLengthError) Error() string {
+	if e.Limit > 0 {
+		if e.Length > 0 {
+			return fmt.Sprintf("message length %d exceeds limit %d", e.Length, e.Limit)
+		}
+		return fmt.Sprintf("message length exceeds limit %d", e.Limit)
+	}
 	return fmt.Sprintf("invalid message length: %d", e.Length)
 }
 
@@ -54,3 +66,178 @@ type ConnectionError struct {
 func (e ConnectionError) Error() string {
 	return fmt.Sprintf("connection error: %s", e.Reason)
 }
+
+type InvalidOptionError struct {
+	Reason string
+}
+
+func (e InvalidOptionError) Error() string {
+	return fmt.Sprintf("invalid option: %s", e.Reason)
+}
+
+type UnknownMessageError struct {
+	Id int32
+}
+
+func (e UnknownMessageError) Error() string {
+	return fmt.Sprintf("unknown message id: %d", e.Id)
+}
+
+type HandlerPanicError struct {
+	Id    int32
+	Value interface{}
+	Stack []byte
+}
+
+func (e HandlerPanicError) Error() string {
+	return fmt.Sprintf("handler panic on message %d: %v", e.Id, e.Value)
+}
+
+type UnsupportedOptionError struct {
+	Option   string
+	Protocol Protocol
+	Side     string
+}
+
+func (e UnsupportedOptionError) Error() string {
+	if e.Side != "" {
+		return fmt.Sprintf("option %s is not supported by %s %s", e.Option, e.Protocol, e.Side)
+	}
+	return fmt.Sprintf("option %s is not supported by protocol %s", e.Option, e.Protocol)
+}
+
+type ServerStateError struct {
+	Reason string
+}
+
+func (e ServerStateError) Error() string {
+	return fmt.Sprintf("server state error: %s", e.Reason)
+}
+
+type ReconnectFailedError struct {
+	Attempts  int
+	LastError error
+}
+
+func (e ReconnectFailedError) Error() string {
+	return fmt.Sprintf("reconnect failed after %d attempts: %v", e.Attempts, e.LastError)
+}
+
+type HeartbeatTimeoutError struct {
+	Idle time.Duration
+}
+
+func (e HeartbeatTimeoutError) Error() string {
+	return fmt.Sprintf("heartbeat timeout: no data for %v", e.Idle)
+}
+
+type UpgradeRejectedError struct {
+	Status int
+	Reason string
+}
+
+func (e UpgradeRejectedError) Error() string {
+	return fmt.Sprintf("websocket upgrade rejected with status %d: %s", e.Status, e.Reason)
+}
+
+// WebSocket关闭帧, 对端关闭时由OnError返回, 也可通过Conn.CloseReason获取
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e CloseError) Error() string {
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Text)
+}
+
+// KCP数据包解密后校验失败, 通常是双方的加密算法或密钥不一致
+type KcpDecryptError struct {
+	Packets uint64
+}
+
+func (e KcpDecryptError) Error() string {
+	return fmt.Sprintf("kcp decrypt failed for %d packets, check cipher and key", e.Packets)
+}
+
+// 群发时部分连接发送失败, 按连接id记录失败原因
+type SendError struct {
+	Errors map[uint32]error
+}
+
+func (e SendError) Error() string {
+	return fmt.Sprintf("send failed for %d connections", len(e.Errors))
+}
+
+// 连接被服务器踢出
+type KickedError struct {
+	Reason string
+}
+
+func (e KickedError) Error() string {
+	return fmt.Sprintf("kicked by server: %s", e.Reason)
+}
+
+// 连接的写队列已满
+type WriteQueueFullError struct{}
+
+func (e WriteQueueFullError) Error() string {
+	return "write queue is full"
+}
+
+// 连接读, 写, 空闲或握手超时, Op为read, write, idle或handshake
+// 通过SetDeadline设置的截止时间到达时Timeout为0
+type TimeoutError struct {
+	Op      string
+	Timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s timeout after %v", e.Op, e.Timeout)
+	}
+	return fmt.Sprintf("%s deadline exceeded", e.Op)
+}
+
+// 服务器因连接限制拒绝了新连接
+type RejectedError struct {
+	Addr   string
+	Reason RejectReason
+}
+
+func (e RejectedError) Error() string {
+	return fmt.Sprintf("reject connection from %s: %s", e.Addr, e.Reason)
+}
+
+// 连接的入站消息超出限速, Limit为messages或bytes, Rate为每秒限额
+type RateLimitedError struct {
+	Limit string
+	Rate  float64
+}
+
+func (e RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s over %g per second", e.Limit, e.Rate)
+}
+
+// 连接产生的错误, 有来源连接时OnError收到该类型, Err为原始错误
+// Phase为产生错误的阶段: handshake, read, write, close或heartbeat
+type ConnError struct {
+	Conn  Conn
+	Phase string
+	Err   error
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("conn %d %s: %v", e.Conn.Identity(), e.Phase, e.Err)
+}
+
+func (e ConnError) Unwrap() error {
+	return e.Err
+}
+
+// 取出ConnError包装的原始错误, 其它错误原样返回
+func UnwrapConnError(err error) error {
+	if ce, ok := err.(ConnError); ok {
+		return ce.Err
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 连接分组, 一个连接可同时加入多个分组
+type connGroups struct {
+	mu     sync.RWMutex
+	groups map[string]map[uint32]Conn
+	joined map[uint32]map[string]struct{}
+}
+
+func (g *connGroups) join(group string, conn Conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.groups == nil {
+		g.groups = make(map[string]map[uint32]Conn)
+		g.joined = make(map[uint32]map[string]struct{})
+	}
+	id := conn.Identity()
+	members, ok := g.groups[group]
+	if !ok {
+		members = make(map[uint32]Conn)
+		g.groups[group] = members
+	}
+	members[id] = conn
+	names, ok := g.joined[id]
+	if !ok {
+		names = make(map[string]struct{})
+		g.joined[id] = names
+	}
+	names[group] = struct{}{}
+}
+
+func (g *connGroups) leave(group string, id uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if members, ok := g.groups[group]; ok {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(g.groups, group)
+		}
+	}
+	if names, ok := g.joined[id]; ok {
+		delete(names, group)
+		if len(names) == 0 {
+			delete(g.joined, id)
+		}
+	}
+}
+
+// 连接断开时退出所有分组
+func (g *connGroups) remove(id uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for group := range g.joined[id] {
+		if members, ok := g.groups[group]; ok {
+			delete(members, id)
+			if len(members) == 0 {
+				delete(g.groups, group)
+			}
+		}
+	}
+	delete(g.joined, id)
+}
+
+func (g *connGroups) members(group string) []Conn {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members := g.groups[group]
+	conns := make([]Conn, 0, len(members))
+	for _, conn := range members {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 广播时并行发送的最大协程数, 连接较多时由固定数量的协程分担, 不为每个连接创建协程
+const fanOutWorkers = 32
+
+// 并行发送消息到多个连接, 单个连接失败不影响其它连接
+// 消息只编码一次, 失败的连接收集到SendError中返回
+func fanOut(codec Codec, conns []Conn, msg *Message) error {
+	if msg == nil {
+		return EmptyMessageError{}
+	}
+	send := func(conn Conn) error {
+		return conn.SendMessage(msg)
+	}
+	if msg.Type != TextMessage {
+		data, err := codec.Encode(msg)
+		if err != nil {
+			return err
+		}
+		send = func(conn Conn) error {
+			return conn.Send(data)
+		}
+	}
+	workers := fanOutWorkers
+	if len(conns) < workers {
+		workers = len(conns)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[uint32]error)
+	var next int32 = -1
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= len(conns) {
+					return
+				}
+				if err := send(conns[i]); err != nil {
+					mu.Lock()
+					failed[conns[i].Identity()] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return SendError{Errors: failed}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package net
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动TCP服务器并连接n个客户端, 返回服务器端的连接
+func newTestGroup(t *testing.T, n int) (Server, *testCallback, []*testCallback, []Client) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cbs []*testCallback
+	var clients []Client
+	for i := 0; i < n; i++ {
+		cb := &testCallback{}
+		client := newTestClient(t, Tcp, cb)
+		if err := client.connect(context.Background(), server.Addr()); err != nil {
+			t.Fatal(err)
+		}
+		cbs = append(cbs, cb)
+		clients = append(clients, client)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == n
+	}) {
+		t.Fatal("not connected", serverCb.errors)
+	}
+	return server, serverCb, cbs, clients
+}
+
+func received(cbs []*testCallback) []int {
+	var counts []int
+	for _, cb := range cbs {
+		messages, _, _ := cb.count()
+		counts = append(counts, messages)
+	}
+	return counts
+}
+
+func TestGetConnection(t *testing.T) {
+	server, serverCb, _, clients := newTestGroup(t, 1)
+	defer server.Close()
+	defer clients[0].Close()
+	serverCb.Lock()
+	conn := serverCb.connected[0]
+	serverCb.Unlock()
+	if c, ok := server.GetConnection(conn.Identity()); !ok || c != conn {
+		t.Fatal("connection not found")
+	}
+	if _, ok := server.GetConnection(conn.Identity() + 1); ok {
+		t.Fatal("found unknown connection")
+	}
+}
+
+func TestBroadcastAndSendTo(t *testing.T) {
+	server, serverCb, cbs, clients := newTestGroup(t, 3)
+	defer server.Close()
+	for _, client := range clients {
+		defer client.Close()
+	}
+	if err := server.Broadcast(&Message{Id: 1, Payload: []byte("all")}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		counts := received(cbs)
+		return counts[0] == 1 && counts[1] == 1 && counts[2] == 1
+	}) {
+		t.Fatal("broadcast not received", received(cbs))
+	}
+
+	serverCb.Lock()
+	id := serverCb.connected[0].Identity()
+	serverCb.Unlock()
+	missing := id + 1000
+	err := server.SendTo([]uint32{id, missing}, &Message{Id: 2, Payload: []byte("some")})
+	sendErr, ok := err.(SendError)
+	if !ok || len(sendErr.Errors) != 1 || sendErr.Errors[missing] == nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		counts := received(cbs)
+		return counts[0]+counts[1]+counts[2] == 4
+	}) {
+		t.Fatal("send to not received", received(cbs))
+	}
+}
+
+func TestGroups(t *testing.T) {
+	server, serverCb, cbs, clients := newTestGroup(t, 3)
+	defer server.Close()
+	for _, client := range clients {
+		defer client.Close()
+	}
+	serverCb.Lock()
+	var ids []uint32
+	for _, conn := range serverCb.connected {
+		ids = append(ids, conn.Identity())
+	}
+	serverCb.Unlock()
+
+	for _, id := range ids[:2] {
+		if err := server.Join("room", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Join("room", ids[2]+1000); err == nil {
+		t.Fatal("joined unknown connection")
+	}
+	if err := server.SendGroup("room", &Message{Id: 1, Payload: []byte("room")}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		counts := received(cbs)
+		return counts[0]+counts[1]+counts[2] == 2
+	}) {
+		t.Fatal("group message not received", received(cbs))
+	}
+
+	server.Leave("room", ids[0])
+	if n := len(server.(*tcpServer).groups.members("room")); n != 1 {
+		t.Fatal("leave failed", n)
+	}
+	// 断开的连接自动退出分组
+	conn, _ := server.GetConnection(ids[1])
+	conn.Close()
+	if !waitFor(time.Second, func() bool {
+		return len(server.(*tcpServer).groups.members("room")) == 0
+	}) {
+		t.Fatal("closed connection still in group")
+	}
+	if err := server.SendGroup("room", &Message{Id: 1, Payload: []byte("empty")}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if counts := received(cbs); counts[0]+counts[1]+counts[2] != 2 {
+		t.Fatal("non member received group message", counts)
+	}
+}
+
+// 记录并发发送数的连接
+type fanOutConn struct {
+	baseConn
+	active, peak *int32
+	fail         bool
+}
+
+func (c *fanOutConn) Send(msg []byte) error {
+	n := atomic.AddInt32(c.active, 1)
+	for {
+		peak := atomic.LoadInt32(c.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(c.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(c.active, -1)
+	if c.fail {
+		return ConnectionError{"failed"}
+	}
+	return nil
+}
+
+func (c *fanOutConn) Close() error {
+	return nil
+}
+
+func TestFanOutBounded(t *testing.T) {
+	var active, peak int32
+	var conns []Conn
+	for i := 0; i < fanOutWorkers*4; i++ {
+		conns = append(conns, &fanOutConn{baseConn: baseConn{identity: uint32(i + 1)}, active: &active, peak: &peak, fail: i%2 == 0})
+	}
+	err := fanOut(NewLengthCodec(), conns, &Message{Id: 1, Payload: []byte("hello")})
+	se, ok := err.(SendError)
+	if !ok || len(se.Errors) != len(conns)/2 {
+		t.Fatal(err)
+	}
+	if p := atomic.LoadInt32(&peak); p > fanOutWorkers || p < 2 {
+		t.Fatal("concurrent sends", p)
+	}
+}
@@ -0,0 +1,109 @@
+package net
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerEvictsIdleConn(t *testing.T) {
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"), WithHeartbeat(20*time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := cb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("idle connection not evicted")
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if _, ok := cb.disconnected[0].CloseReason().(HeartbeatTimeoutError); !ok {
+		t.Fatal(cb.disconnected[0].CloseReason())
+	}
+	if len(cb.errors) != 1 {
+		t.Fatal(cb.errors)
+	}
+	if _, ok := UnwrapConnError(cb.errors[0]).(HeartbeatTimeoutError); !ok {
+		t.Fatal(cb.errors[0])
+	}
+}
+
+func TestServerAnswersPing(t *testing.T) {
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	codec := NewLengthCodec()
+	ping, _ := codec.Encode(&Message{Id: MessageIdPing})
+	conn.Write(ping)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := codec.Decode(buf[:n])
+	if err != nil || msg.Id != MessageIdPong {
+		t.Fatal(msg, err)
+	}
+	if messages, _, _ := cb.count(); messages != 0 {
+		t.Fatal("heartbeat delivered to callback")
+	}
+}
+
+func TestClientHeartbeatKeepsAlive(t *testing.T) {
+	for _, protocol := range []Protocol{Tcp, WebSocket, Kcp} {
+		serverCb := &testCallback{}
+		server, err := Listen(protocol, 0, serverCb, WithBindAddress("127.0.0.1"), WithHeartbeat(20*time.Millisecond, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := server.Addr()
+		if protocol == WebSocket {
+			addr = "ws://" + addr
+		}
+		cb := &testCallback{}
+		client := newTestClient(t, protocol, cb, WithHeartbeat(10*time.Millisecond, 10))
+		if err := client.connect(context.Background(), addr); err != nil {
+			t.Fatal(protocol, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := serverCb.count()
+			return connected == 1
+		}) {
+			t.Fatal(protocol, "not connected")
+		}
+		time.Sleep(200 * time.Millisecond)
+		if _, _, disconnected := serverCb.count(); disconnected != 0 {
+			t.Fatal(protocol, "client evicted", serverCb.errors)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestHeartbeatRawCodec(t *testing.T) {
+	if _, err := newOptions(Tcp, false, []Option{WithHeartbeat(time.Second, 3), WithCodec(NewRawCodec())}); err == nil {
+		t.Fail()
+	}
+	if _, err := newOptions(Tcp, true, []Option{WithHeartbeat(time.Second, 3), WithCodec(NewRawCodec())}); err != nil {
+		t.Fatal(err)
+	}
+}
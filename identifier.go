@@ -34,15 +34,18 @@ func NewIdentifier() *Identifier {
 }
 
 func (i *Identifier) GenIdentity() uint32 {
-	id := atomic.LoadUint32(&i.id)
-	if id >= i.min && id < i.max {
-		atomic.AddUint32(&i.id, 1)
-	} else {
-		atomic.StoreUint32(&i.id, i.min)
+	for {
+		id := atomic.LoadUint32(&i.id)
+		next := id + 1
+		if id < i.min || id >= i.max {
+			next = i.min
+		}
+		if atomic.CompareAndSwapUint32(&i.id, id, next) {
+			return next
+		}
 	}
-	return atomic.LoadUint32(&i.id)
 }
 
 func (i *Identifier) IsValidIdentity(id uint32) bool {
-	return id <= i.min && id >= i.max
+	return id >= i.min && id <= i.max
 }
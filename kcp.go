@@ -15,192 +15,334 @@
 package net
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
 	"net"
-	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/xtaci/kcp-go"
+	"golang.org/x/crypto/pbkdf2"
 )
 
+// KCP服务器, 可获取所有连接的汇总统计
+type KcpServer interface {
+	Server
+	Stats() KcpServerStats
+}
+
+// KCP服务器汇总统计
+type KcpServerStats struct {
+	// 当前连接数
+	Connections int
+	// 所有连接收发的应用层字节数之和
+	BytesSent     uint64
+	BytesReceived uint64
+	// kcp-go的全局统计, 包含进程内所有KCP会话
+	RetransSegs     uint64
+	FastRetransSegs uint64
+	LostSegs        uint64
+	FECRecovered    uint64
+	FECErrs         uint64
+	InCsumErrors    uint64
+}
+
+// 被拒绝的KCP对端在此时间内重传的包不再创建连接
+// 对端持续重传时从最后一次重传开始计算
+const kcpRejectWindow = 5 * time.Second
+
 type kcpServer struct {
-	sync.RWMutex
-	listener net.Listener
-	callback Callback
-	clients  *sync.Map
+	baseServer
+	listener *kcp.Listener
+	// 最近被拒绝的对端地址及过期时间, 同一对端只计一次拒绝
+	rejectMutex sync.Mutex
+	rejects     map[string]time.Time
 }
 
-func (s *kcpServer) listen(port int, callback Callback) error {
-	listener, err := kcp.Listen(":" + strconv.Itoa(port))
+func (s *kcpServer) bind(addr string) (net.Addr, error) {
+	var dataShards, parityShards int
+	if s.options.kcp != nil {
+		dataShards, parityShards = s.options.kcp.DataShards, s.options.kcp.ParityShards
+	}
+	block, err := newBlockCrypt(s.options, func(err error) {
+		s.report(nil, "decrypt", err)
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	listener, err := kcp.ListenWithOptions(addr, block, dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if err := configSocket(listener, s.options.kcp); err != nil {
+		listener.Close()
+		return nil, err
 	}
-	defer func() {
-		err := listener.Close()
-		if err != nil && callback != nil {
-			callback.OnError(err)
-		}
-	}()
 	s.listener = listener
-	s.clients = &sync.Map{}
-	s.callback = callback
+	s.goAccept(func() {
+		s.accept(listener)
+	})
+	return listener.Addr(), nil
+}
+
+func (s *kcpServer) unbind(ctx context.Context) error {
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *kcpServer) accept(listener *kcp.Listener) {
 	for {
-		conn, err := listener.Accept()
+		conn, err := listener.AcceptKCP()
 		if err != nil {
-			if s.callback != nil {
-				s.callback.OnError(err)
+			if s.acceptError(err) {
+				continue
 			}
+			return
+		}
+		addr := conn.RemoteAddr().String()
+		if s.recentlyRejected(addr) {
+			// 被拒绝的对端重传时会再次创建会话, 直接关闭不再报告
+			_ = conn.Close()
 			continue
 		}
-		c := &kcpConn{conn: conn}
-		c.setState(ConnStateConnected)
-		s.clients.Store(c.Identity(), c)
-		if s.callback != nil {
-			s.callback.OnConnected(c)
+		if err := s.acquireConn(addr); err != nil {
+			if cerr := conn.Close(); cerr != nil {
+				s.report(nil, "reject", cerr)
+			}
+			if _, ok := err.(RejectedError); ok {
+				s.rememberReject(addr)
+			}
+			s.reject(err)
+			continue
 		}
-		go s.handleConnection(c, callback)
+		configSession(conn, s.options.kcp)
+		s.serveConn(newKcpConn(conn, s.options), addr)
 	}
 }
 
-// 处理消息流
-func (s *kcpServer) handleConnection(conn Conn, callback Callback) {
-	buf := make([]byte, 4096) // 4KB
-	byteBuffer := make([]byte, 0)
-	defer func() {
-		err := conn.Close()
-		if err != nil && callback != nil {
-			callback.OnError(err)
-		}
-	}()
-	for {
-		l, err := conn.read(&buf)
-		if err != nil {
-			conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(conn)
-			}
-			s.clients.Delete(conn.Identity())
-			break
-		}
-		byteBuffer = append(byteBuffer, buf[:l]...)
-		if callback != nil {
-			callback.OnMessage(conn, byteBuffer)
-		}
+// 对端是否在最近被拒绝过, 是则延长其过期时间
+func (s *kcpServer) recentlyRejected(addr string) bool {
+	s.rejectMutex.Lock()
+	defer s.rejectMutex.Unlock()
+	expire, ok := s.rejects[addr]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	if now.After(expire) {
+		delete(s.rejects, addr)
+		return false
 	}
+	s.rejects[addr] = now.Add(kcpRejectWindow)
+	return true
 }
 
-func (s *kcpServer) GetConnection(identity uint32) (Conn, bool) {
-	if s.clients == nil {
-		return nil, false
-	} else {
-		if identity, ok := s.clients.Load(identifier); ok {
-			return identity.(Conn), ok
-		} else {
-			return nil, false
+func (s *kcpServer) rememberReject(addr string) {
+	s.rejectMutex.Lock()
+	defer s.rejectMutex.Unlock()
+	now := time.Now()
+	if s.rejects == nil {
+		s.rejects = make(map[string]time.Time)
+	}
+	for a, expire := range s.rejects {
+		if now.After(expire) {
+			delete(s.rejects, a)
 		}
 	}
+	s.rejects[addr] = now.Add(kcpRejectWindow)
 }
 
-func (s *kcpServer) Close() error {
+func (s *kcpServer) Stats() KcpServerStats {
+	var stats KcpServerStats
 	if s.clients != nil {
 		s.clients.Range(func(key, value interface{}) bool {
-			conn := value.(Conn)
-			if conn != nil {
-				err := conn.Close()
-				if err != nil && s.callback != nil {
-					s.callback.OnError(err)
-				}
+			conn, ok := value.(KcpConn)
+			if !ok {
+				return true
 			}
+			cs := conn.Stats()
+			stats.Connections++
+			stats.BytesSent += cs.BytesSent
+			stats.BytesReceived += cs.BytesReceived
 			return true
 		})
 	}
-	if s.listener != nil {
-		err := s.listener.Close()
-		if err != nil {
-			return err
-		}
-		s.listener = nil
-	}
-	return nil
+	snmp := kcp.DefaultSnmp.Copy()
+	stats.RetransSegs = snmp.RetransSegs
+	stats.FastRetransSegs = snmp.FastRetransSegs
+	stats.LostSegs = snmp.LostSegs
+	stats.FECRecovered = snmp.FECRecovered
+	stats.FECErrs = snmp.FECErrs
+	stats.InCsumErrors = snmp.InCsumErrors
+	return stats
 }
 
 type kcpClient struct {
-	sync.Mutex
-	serverAddr string
-	callback   Callback
-	conn       Conn
+	baseClient
 }
 
-func (c *kcpClient) Reconnect() error {
-	return c.connect(c.serverAddr, c.callback)
+// KCP基于UDP, 建立会话不需要等待服务器响应, ctx仅在开始前检查
+func (c *kcpClient) dial(ctx context.Context, serverAddr string) (Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var dataShards, parityShards int
+	if c.options.kcp != nil {
+		dataShards, parityShards = c.options.kcp.DataShards, c.options.kcp.ParityShards
+	}
+	block, err := newBlockCrypt(c.options, func(err error) {
+		c.report(nil, "decrypt", err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := kcp.DialWithOptions(serverAddr, block, dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if err := configSocket(conn, c.options.kcp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	configSession(conn, c.options.kcp)
+	return newKcpConn(conn, c.options), nil
 }
 
-func (c *kcpClient) connect(serverAddr string, callback Callback) error {
-	c.serverAddr = serverAddr
-	c.callback = callback
-	conn, err := kcp.Dial(serverAddr)
-	if err != nil {
-		return err
+// 可设置UDP socket参数的对象, 服务器为Listener, 客户端为会话
+type kcpSocket interface {
+	SetDSCP(int) error
+	SetReadBuffer(int) error
+	SetWriteBuffer(int) error
+}
+
+func configSocket(socket kcpSocket, o *KcpOptions) error {
+	if o == nil {
+		return nil
 	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
+	if o.DSCP > 0 {
+		if err := socket.SetDSCP(o.DSCP); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := socket.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := socket.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
 		}
-	}()
-	c.conn = &kcpConn{conn: conn}
-	c.conn.setState(ConnStateConnected)
-	if callback != nil {
-		callback.OnConnected(c.conn)
 	}
-	c.handleConnection(c.conn, callback)
 	return nil
 }
 
-// 处理消息流
-func (c *kcpClient) handleConnection(conn Conn, callback Callback) {
-	buf := make([]byte, 4096) // 4KB
-	byteBuffer := make([]byte, 0)
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
-		}
-	}()
-	for {
-		l, err := conn.read(&buf)
-		if err != nil {
-			c.conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(c.conn)
-			}
-			c.conn = nil
-			break
-		}
-		byteBuffer = append(byteBuffer, buf[:l]...)
-		if callback != nil {
-			callback.OnMessage(conn, byteBuffer)
+// 设置KCP会话参数, 未设置的使用kcp-go默认值
+func configSession(session *kcp.UDPSession, o *KcpOptions) {
+	if o == nil {
+		return
+	}
+	if o.NoDelay || o.Interval > 0 || o.Resend > 0 || o.NoCongestion {
+		interval := o.Interval
+		if interval == 0 {
+			interval = 100
 		}
+		session.SetNoDelay(boolToInt(o.NoDelay), interval, o.Resend, boolToInt(o.NoCongestion))
 	}
+	if o.SndWnd > 0 || o.RcvWnd > 0 {
+		session.SetWindowSize(o.SndWnd, o.RcvWnd)
+	}
+	if o.Mtu > 0 {
+		session.SetMtu(o.Mtu)
+	}
+	session.SetACKNoDelay(o.AckNoDelay)
+	session.SetStreamMode(o.StreamMode)
 }
 
-func (c *kcpClient) Send(msg []byte) error {
-	if c.conn != nil && c.conn.State() == ConnStateConnected {
-		return c.conn.Send(msg)
-	} else {
-		return ConnectionError{"Send failed: connection was not built"}
+func boolToInt(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
 }
 
-func (c *kcpClient) Close() error {
-	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
-		}
-		c.conn = nil
+const (
+	// 与kcptun一致的PBKDF2参数
+	kcpSalt       = "kcp-go"
+	kcpIterations = 4096
+	kcpKeyLen     = 32
+	// kcp-go加密数据包的nonce和校验和长度
+	kcpNonceSize = 16
+	kcpCrcSize   = 4
+	// 解密失败的最小报告间隔
+	kcpDecryptReportInterval = time.Second
+)
+
+// 支持的加密算法, 密钥取派生密钥的前keyLen字节
+var kcpCiphers = map[string]struct {
+	keyLen int
+	create func([]byte) (kcp.BlockCrypt, error)
+}{
+	"aes":      {32, kcp.NewAESBlockCrypt},
+	"aes-128":  {16, kcp.NewAESBlockCrypt},
+	"aes-192":  {24, kcp.NewAESBlockCrypt},
+	"salsa20":  {32, kcp.NewSalsa20BlockCrypt},
+	"sm4":      {16, kcp.NewSM4BlockCrypt},
+	"blowfish": {32, kcp.NewBlowfishBlockCrypt},
+	"twofish":  {32, kcp.NewTwofishBlockCrypt},
+	"cast5":    {16, kcp.NewCast5BlockCrypt},
+	"3des":     {24, kcp.NewTripleDESBlockCrypt},
+	"tea":      {16, kcp.NewTEABlockCrypt},
+	"xtea":     {16, kcp.NewXTEABlockCrypt},
+	"xor":      {32, kcp.NewSimpleXORBlockCrypt},
+	"none":     {32, kcp.NewNoneBlockCrypt},
+}
+
+// 根据配置创建加密器, 未配置时返回nil
+// 解密后校验失败的数据包通过report报告KcpDecryptError
+func newBlockCrypt(o *options, report func(error)) (kcp.BlockCrypt, error) {
+	if o.kcpCipher == "" {
+		return nil, nil
+	}
+	cipher, ok := kcpCiphers[o.kcpCipher]
+	if !ok {
+		return nil, InvalidOptionError{"unknown kcp cipher " + o.kcpCipher}
+	}
+	key := pbkdf2.Key([]byte(o.kcpKey), []byte(kcpSalt), kcpIterations, kcpKeyLen, sha1.New)
+	block, err := cipher.create(key[:cipher.keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return &checkedBlockCrypt{BlockCrypt: block, report: report}, nil
+}
+
+// 检查解密结果的加密器
+// kcp-go会静默丢弃校验失败的数据包, 密钥不一致时连接表现为无响应, 在此提前发现并报告
+type checkedBlockCrypt struct {
+	kcp.BlockCrypt
+	failed   uint64
+	reported int64
+	report   func(error)
+}
+
+func (c *checkedBlockCrypt) Decrypt(dst, src []byte) {
+	c.BlockCrypt.Decrypt(dst, src)
+	if len(dst) < kcpNonceSize+kcpCrcSize {
+		return
+	}
+	data := dst[kcpNonceSize:]
+	if crc32.ChecksumIEEE(data[kcpCrcSize:]) == binary.LittleEndian.Uint32(data) {
+		return
+	}
+	atomic.AddUint64(&c.failed, 1)
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&c.reported)
+	if now-last >= int64(kcpDecryptReportInterval) && atomic.CompareAndSwapInt64(&c.reported, last, now) {
+		c.report(KcpDecryptError{Packets: atomic.SwapUint64(&c.failed, 0)})
 	}
-	return nil
 }
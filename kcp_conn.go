@@ -15,42 +15,104 @@
 package net
 
 import (
-	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/kcp-go"
 )
 
+// KCP连接, 可获取会话统计
+type KcpConn interface {
+	Conn
+	Stats() KcpStats
+}
+
+// KCP会话统计
+// kcp-go只导出了会话id, RTT和窗口等协议状态无法获取, 全局统计见KcpServerStats
+type KcpStats struct {
+	// 会话id
+	Conv uint32
+	// 收发的应用层字节数
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
 type kcpConn struct {
 	baseConn
-	conn net.Conn
+	conn          *kcp.UDPSession
+	bytesSent     uint64
+	bytesReceived uint64
+	// 设置到会话上的读写截止时间(UnixNano), 用于识别超时错误
+	readExpire  int64
+	writeExpire int64
+}
+
+func newKcpConn(conn *kcp.UDPSession, o *options) *kcpConn {
+	c := &kcpConn{conn: conn}
+	c.setup(c, o)
+	return c
 }
 
 func (c *kcpConn) Send(msg []byte) error {
-	if c.conn != nil {
-		if msg == nil || len(msg) == 0 {
-			return EmptyMessageError{}
-		}
-		_, err := c.conn.Write(msg)
-		if err != nil {
-			return err
-		}
-		return nil
-	} else {
+	if c.conn == nil {
 		return ConnectionError{"Send failed, connection was not built"}
 	}
+	return c.send(BinaryMessage, msg)
+}
+
+func (c *kcpConn) SendMessage(msg *Message) error {
+	_, data, err := c.frame(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+func (c *kcpConn) writeFrame(typ MessageType, data []byte) error {
+	c.prepareWrite()
+	n, err := c.conn.Write(data)
+	atomic.AddUint64(&c.bytesSent, uint64(n))
+	return c.timeoutError("write", c.kcpError(err, &c.writeExpire))
+}
+
+func (c *kcpConn) ping() error {
+	return c.SendMessage(&Message{Id: MessageIdPing})
 }
 
 func (c *kcpConn) read(buf *[]byte) (int, error) {
+	if c.conn != nil && !c.isClosed() {
+		c.prepareRead()
+		n, err := c.conn.Read(*buf)
+		if n > 0 {
+			atomic.AddUint64(&c.bytesReceived, uint64(n))
+		}
+		// 关闭后读取到的数据不再处理
+		if !c.isClosed() {
+			return n, c.timeoutError("read", c.kcpError(err, &c.readExpire))
+		}
+	}
+	return -1, ConnectionError{"Read failed, connection was closed"}
+}
+
+func (c *kcpConn) setReadDeadline(t time.Time) error {
 	if c.conn != nil {
-		return c.conn.Read(*buf)
+		storeDeadline(&c.readExpire, t)
+		return c.conn.SetReadDeadline(t)
 	}
-	return -1, nil
+	return nil
 }
 
-func (c *kcpConn) Close() error {
+func (c *kcpConn) setWriteDeadline(t time.Time) error {
 	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
-		}
-		c.conn = nil
+		storeDeadline(&c.writeExpire, t)
+		return c.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *kcpConn) Close() error {
+	if c.conn != nil && c.markClosed() {
+		return c.closeWriter(c.conn.Close)
 	}
 	return nil
 }
@@ -72,3 +134,41 @@ func (c *kcpConn) LocalAddr() string {
 func (c *kcpConn) NetProtocol() Protocol {
 	return Kcp
 }
+
+// kcp-go v5.4.4的超时错误没有实现net.Error, 不能据此判断
+// 读写失败时会话截止时间已到达即为超时, 转换为标准超时错误
+func (c *kcpConn) kcpError(err error, deadline *int64) error {
+	if err == nil || isTimeout(err) {
+		return err
+	}
+	if d := atomic.LoadInt64(deadline); d != 0 && time.Now().UnixNano() >= d {
+		return kcpTimeoutError{}
+	}
+	return err
+}
+
+type kcpTimeoutError struct{}
+
+func (kcpTimeoutError) Error() string   { return "timeout" }
+func (kcpTimeoutError) Timeout() bool   { return true }
+func (kcpTimeoutError) Temporary() bool { return true }
+
+// 记录设置到会话上的截止时间, 零值表示不限制
+func storeDeadline(deadline *int64, t time.Time) {
+	var d int64
+	if !t.IsZero() {
+		d = t.UnixNano()
+	}
+	atomic.StoreInt64(deadline, d)
+}
+
+func (c *kcpConn) Stats() KcpStats {
+	stats := KcpStats{
+		BytesSent:     atomic.LoadUint64(&c.bytesSent),
+		BytesReceived: atomic.LoadUint64(&c.bytesReceived),
+	}
+	if c.conn != nil {
+		stats.Conv = c.conn.GetConv()
+	}
+	return stats
+}
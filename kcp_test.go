@@ -0,0 +1,159 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 连接KCP服务器并完成一次消息往返
+func kcpRoundTrip(t *testing.T, addr string, opts ...Option) {
+	cb := &testCallback{}
+	client := newTestClient(t, Kcp, cb, opts...)
+	if err := client.connect(context.Background(), addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 9, Payload: make([]byte, 8192)})
+	if !waitFor(2*time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("no echo", cb.errors)
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.messages[0].Id != 9 || len(cb.messages[0].Payload) != 8192 {
+		t.Fatal(cb.messages[0].Id, len(cb.messages[0].Payload))
+	}
+}
+
+func TestKcpOptions(t *testing.T) {
+	kcpOptions := KcpFast3()
+	kcpOptions.SndWnd, kcpOptions.RcvWnd = 256, 256
+	kcpOptions.Mtu = 1200
+	kcpOptions.AckNoDelay = true
+	kcpOptions.StreamMode = true
+	kcpOptions.DataShards, kcpOptions.ParityShards = 10, 3
+	kcpOptions.ReadBuffer, kcpOptions.WriteBuffer = 1<<20, 1<<20
+	kcpOptions.DSCP = 46
+
+	server, err := Listen(Kcp, 0, echoCallback(), WithBindAddress("127.0.0.1"), WithKcpOptions(kcpOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	kcpRoundTrip(t, server.Addr(), WithKcpOptions(kcpOptions))
+}
+
+func TestKcpPresets(t *testing.T) {
+	for _, preset := range []KcpOptions{KcpNormal(), KcpFast(), KcpFast2(), KcpFast3()} {
+		server, err := Listen(Kcp, 0, echoCallback(), WithBindAddress("127.0.0.1"), WithKcpOptions(preset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kcpRoundTrip(t, server.Addr(), WithKcpOptions(preset))
+		server.Close()
+	}
+}
+
+func TestKcpOptionsInvalid(t *testing.T) {
+	cases := []KcpOptions{
+		{Interval: -1},
+		{Mtu: 10},
+		{DataShards: 10},
+		{DSCP: 64},
+		{ReadBuffer: -1},
+	}
+	for i, c := range cases {
+		if _, err := newOptions(Kcp, true, []Option{WithKcpOptions(c)}); err == nil {
+			t.Errorf("case %d: expect error", i)
+		} else if _, ok := err.(InvalidOptionError); !ok {
+			t.Errorf("case %d: expect InvalidOptionError, got %v", i, err)
+		}
+	}
+	if _, err := newOptions(Kcp, true, []Option{WithKcpCrypt("rot13", "secret")}); err == nil {
+		t.Error("unknown cipher accepted")
+	}
+	if _, err := newOptions(Kcp, true, []Option{WithKcpCrypt("aes", "")}); err == nil {
+		t.Error("empty key accepted")
+	}
+}
+
+func TestKcpCrypt(t *testing.T) {
+	for _, cipher := range []string{"aes", "salsa20", "sm4", "3des", "xor"} {
+		server, err := Listen(Kcp, 0, echoCallback(), WithBindAddress("127.0.0.1"), WithKcpCrypt(cipher, "secret"))
+		if err != nil {
+			t.Fatal(cipher, err)
+		}
+		kcpRoundTrip(t, server.Addr(), WithKcpCrypt(cipher, "secret"))
+		server.Close()
+	}
+}
+
+func TestKcpCryptMismatch(t *testing.T) {
+	serverCb := echoCallback()
+	server, err := Listen(Kcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithKcpCrypt("aes", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, Kcp, cb, WithKcpCrypt("aes", "wrong"))
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+	if !waitFor(time.Second, func() bool {
+		serverCb.Lock()
+		defer serverCb.Unlock()
+		return len(serverCb.errors) > 0
+	}) {
+		t.Fatal("mismatched key not reported")
+	}
+	serverCb.Lock()
+	defer serverCb.Unlock()
+	if e, ok := serverCb.errors[0].(KcpDecryptError); !ok || e.Packets == 0 {
+		t.Fatal(serverCb.errors[0])
+	}
+	if len(serverCb.connected) != 0 {
+		t.Fatal("accepted connection with wrong key")
+	}
+}
+
+func TestKcpStats(t *testing.T) {
+	serverCb := echoCallback()
+	server, err := Listen(Kcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithKcpOptions(KcpFast3()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	kcpRoundTrip(t, server.Addr(), WithKcpOptions(KcpFast3()))
+
+	serverCb.Lock()
+	conn := serverCb.connected[0].(KcpConn)
+	serverCb.Unlock()
+	stats := conn.Stats()
+	if stats.Conv == 0 || stats.BytesReceived == 0 || stats.BytesSent == 0 {
+		t.Fatal(stats)
+	}
+
+	serverStats := server.(KcpServer).Stats()
+	if serverStats.BytesReceived < stats.BytesReceived {
+		t.Fatal(serverStats)
+	}
+}
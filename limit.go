@@ -0,0 +1,151 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"math"
+	"net"
+	"time"
+)
+
+// 连接被拒绝的原因
+type RejectReason int
+
+const (
+	// 超出服务器最大连接数
+	RejectMaxConnections RejectReason = iota
+	// 超出单个IP的最大连接数
+	RejectMaxConnectionsPerIP
+	// 单个IP新建连接过于频繁
+	RejectAcceptRate
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectMaxConnections:
+		return "too many connections"
+	case RejectMaxConnectionsPerIP:
+		return "too many connections from ip"
+	case RejectAcceptRate:
+		return "accept rate exceeded"
+	default:
+		return "unknown"
+	}
+}
+
+// 连接被拒绝回调, Callback可选实现, 未实现时拒绝通过OnError报告
+type RejectCallback interface {
+	OnRejected(RejectedError)
+}
+
+// 令牌桶, 每秒补充rate个令牌, 最多累积burst个, 不是并发安全的
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// 取走n个令牌, 令牌不足时返回false
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	b.refill(now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// 令牌已补满, 可以丢弃
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// 按IP记录的连接限制状态超过该数量时, 清理已补满的令牌桶
+const maxIdleAcceptBuckets = 1024
+
+// 按IP统计连接数和新建连接速率, 需由调用方加锁
+type connLimiter struct {
+	perIP   map[string]int
+	buckets map[string]*tokenBucket
+}
+
+// 检查来自addr的新连接是否超出限制, 未超出时占用名额
+func (l *connLimiter) acquire(o *options, total int, addr string) error {
+	ip := remoteIP(addr)
+	if o.maxConnections > 0 && total >= o.maxConnections {
+		return RejectedError{Addr: addr, Reason: RejectMaxConnections}
+	}
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	if o.maxConnectionsPerIP > 0 && l.perIP[ip] >= o.maxConnectionsPerIP {
+		return RejectedError{Addr: addr, Reason: RejectMaxConnectionsPerIP}
+	}
+	// 最后检查接入速率, 被其它限制拒绝的连接不消耗令牌
+	if o.acceptRate > 0 {
+		now := time.Now()
+		if l.buckets == nil {
+			l.buckets = make(map[string]*tokenBucket)
+		}
+		if len(l.buckets) > maxIdleAcceptBuckets {
+			for k, b := range l.buckets {
+				if b.full(now) {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b, ok := l.buckets[ip]
+		if !ok {
+			b = newTokenBucket(o.acceptRate, o.acceptBurst, now)
+			l.buckets[ip] = b
+		}
+		if !b.take(1, now) {
+			return RejectedError{Addr: addr, Reason: RejectAcceptRate}
+		}
+	}
+	l.perIP[ip]++
+	return nil
+}
+
+// 释放acquire占用的名额
+func (l *connLimiter) release(addr string) {
+	ip := remoteIP(addr)
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+// 取地址中的IP部分, 无法解析时使用完整地址
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,222 @@
+package net
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type rejectCallback struct {
+	testCallback
+	rejectMutex sync.Mutex
+	rejected    []RejectedError
+}
+
+func (c *rejectCallback) OnRejected(err RejectedError) {
+	c.rejectMutex.Lock()
+	defer c.rejectMutex.Unlock()
+	c.rejected = append(c.rejected, err)
+}
+
+func (c *rejectCallback) reasons() []RejectReason {
+	c.rejectMutex.Lock()
+	defer c.rejectMutex.Unlock()
+	var reasons []RejectReason
+	for _, err := range c.rejected {
+		reasons = append(reasons, err.Reason)
+	}
+	return reasons
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+	if !b.take(1, now) || !b.take(1, now) || b.take(1, now) {
+		t.Fatal("burst not enforced")
+	}
+	if b.take(1, now.Add(50*time.Millisecond)) {
+		t.Fatal("refilled too fast")
+	}
+	if !b.take(1, now.Add(100*time.Millisecond)) {
+		t.Fatal("not refilled")
+	}
+	if !b.full(now.Add(time.Second)) || b.tokens != 2 {
+		t.Fatal("refill exceeds burst", b.tokens)
+	}
+}
+
+func TestConnLimiterRateCharge(t *testing.T) {
+	o, err := newOptions(Tcp, true, []Option{WithMaxConnectionsPerIP(1), WithAcceptRate(0.001, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l connLimiter
+	addr := "10.0.0.1:1000"
+	if err := l.acquire(o, 0, addr); err != nil {
+		t.Fatal(err)
+	}
+	// 被连接数限制拒绝的连接不消耗接入令牌
+	for i := 0; i < 3; i++ {
+		if err := l.acquire(o, 1, addr); err != (RejectedError{Addr: addr, Reason: RejectMaxConnectionsPerIP}) {
+			t.Fatal(i, err)
+		}
+	}
+	l.release(addr)
+	if err := l.acquire(o, 0, addr); err != nil {
+		t.Fatal(err)
+	}
+	l.release(addr)
+	if err := l.acquire(o, 0, addr); err != (RejectedError{Addr: addr, Reason: RejectAcceptRate}) {
+		t.Fatal(err)
+	}
+}
+
+// 建立TCP连接, 返回连接是否被服务器立即关闭
+func tcpRejected(t *testing.T, addr string) (net.Conn, bool) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		conn.SetReadDeadline(time.Time{})
+		return conn, false
+	}
+	conn.Close()
+	return nil, true
+}
+
+func TestConnectionLimits(t *testing.T) {
+	cases := []struct {
+		option   Option
+		accepted int
+		reason   RejectReason
+	}{
+		{WithMaxConnections(2), 2, RejectMaxConnections},
+		{WithMaxConnectionsPerIP(1), 1, RejectMaxConnectionsPerIP},
+		{WithAcceptRate(0.1, 2), 2, RejectAcceptRate},
+	}
+	for i, c := range cases {
+		cb := &rejectCallback{}
+		server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"), c.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var conns []net.Conn
+		for j := 0; j <= c.accepted; j++ {
+			conn, rejected := tcpRejected(t, server.Addr())
+			if rejected != (j == c.accepted) {
+				t.Fatal(i, j, "unexpected result", rejected)
+			}
+			if conn != nil {
+				conns = append(conns, conn)
+			}
+		}
+		if reasons := cb.reasons(); len(reasons) != 1 || reasons[0] != c.reason {
+			t.Fatal(i, reasons)
+		}
+		if server.Rejected() != 1 {
+			t.Fatal(i, server.Rejected())
+		}
+		cb.Lock()
+		if len(cb.errors) != 0 {
+			t.Fatal(i, cb.errors)
+		}
+		cb.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		server.Close()
+	}
+}
+
+func TestConnectionLimitReleased(t *testing.T) {
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"), WithMaxConnectionsPerIP(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, rejected := tcpRejected(t, server.Addr())
+	if rejected {
+		t.Fatal("first connection rejected")
+	}
+	if _, rejected := tcpRejected(t, server.Addr()); !rejected {
+		t.Fatal("second connection accepted")
+	}
+	// 未实现RejectCallback时通过OnError报告
+	if err := firstError(t, cb, time.Second); err.(RejectedError).Reason != RejectMaxConnectionsPerIP {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !waitFor(time.Second, func() bool {
+		return server.Count() == 0
+	}) {
+		t.Fatal("not disconnected")
+	}
+	if conn, rejected := tcpRejected(t, server.Addr()); rejected {
+		t.Fatal("limit not released")
+	} else {
+		conn.Close()
+	}
+}
+
+func TestWsConnectionLimit(t *testing.T) {
+	cb := &rejectCallback{}
+	server, err := Listen(WebSocket, 0, cb, WithBindAddress("127.0.0.1"), WithMaxConnectionsPerIP(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(WebSocket, "ws://"+server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_, err = Connect(WebSocket, "ws://"+server.Addr(), &testCallback{})
+	if err == nil {
+		t.Fatal("second connection accepted")
+	}
+	resp, err := http.Get("http://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal(resp.Status)
+	}
+	if reasons := cb.reasons(); len(reasons) != 2 || reasons[0] != RejectMaxConnectionsPerIP {
+		t.Fatal(reasons)
+	}
+}
+
+func TestKcpConnectionLimit(t *testing.T) {
+	cb := &rejectCallback{}
+	server, err := Listen(Kcp, 0, cb, WithBindAddress("127.0.0.1"), WithMaxConnections(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	for i := 0; i < 2; i++ {
+		client, err := Connect(Kcp, server.Addr(), &testCallback{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		// KCP连接在收到第一个包后才被服务器接收
+		client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+	}
+	if !waitFor(time.Second, func() bool {
+		return server.Rejected() > 0
+	}) {
+		t.Fatal("not rejected")
+	}
+	// 被拒绝的客户端重传时不再重复拒绝
+	time.Sleep(time.Second)
+	if reasons := cb.reasons(); len(reasons) != 1 || reasons[0] != RejectMaxConnections || server.Rejected() != 1 || server.Count() != 1 {
+		t.Fatal(reasons, server.Count())
+	}
+}
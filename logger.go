@@ -0,0 +1,144 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 日志级别
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// 结构化日志接口, 通过WithLogger设置, 默认不输出
+// keyvals为交替的键和值, 键为字符串, 如conn, remote, protocol, phase, error
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
+
+// 标准库logger适配
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// 使用标准库logger输出日志, 低于level的日志被忽略, logger为nil时使用标准库默认logger
+// 每条日志为一行, 格式为 "LEVEL msg key=value ..."
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		if i+1 < len(keyvals) {
+			b.WriteString(logValue(keyvals[i+1]))
+		}
+	}
+	_ = l.logger.Output(2, b.String())
+}
+
+// 含空白, 等号或引号的值加引号输出
+func logValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n=\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// 连接的日志字段
+func connFields(o *options, conn Conn, phase string) []interface{} {
+	side := "client"
+	if o.isServer {
+		side = "server"
+	}
+	fields := []interface{}{"protocol", o.protocol, "side", side}
+	if conn != nil {
+		fields = append(fields, "conn", conn.Identity(), "remote", conn.RemoteAddr())
+	}
+	if phase != "" {
+		fields = append(fields, "phase", phase)
+	}
+	return fields
+}
+
+// 错误的日志级别, 对端正常断开为Info, 连接被拒绝为Warn
+func errorLevel(phase string, err error) LogLevel {
+	if err == io.EOF {
+		return LevelInfo
+	}
+	if phase == "reject" {
+		return LevelWarn
+	}
+	return LevelError
+}
+
+// 报告phase阶段产生的错误: 写入日志后交给OnError, 有来源连接时包装为ConnError
+func reportError(o *options, callback Callback, conn Conn, phase string, err error) {
+	if err == nil {
+		return
+	}
+	o.logger.Log(errorLevel(phase, err), "net error", append(connFields(o, conn, phase), "error", err)...)
+	if callback == nil {
+		return
+	}
+	if conn != nil {
+		err = ConnError{Conn: conn, Phase: phase, Err: err}
+	}
+	callback.OnError(err)
+}
@@ -0,0 +1,103 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordLogger struct {
+	sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[keyvals[i].(string)] = keyvals[i+1]
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.entries = append(l.entries, logEntry{level, msg, fields})
+}
+
+func (l *recordLogger) find(msg string) (logEntry, bool) {
+	l.Lock()
+	defer l.Unlock()
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	logger.Log(LevelDebug, "hidden")
+	logger.Log(LevelWarn, "net error", "conn", 3, "remote", "127.0.0.1:80", "error", "read tcp: i/o timeout", "empty", "")
+	if want := "WARN net error conn=3 remote=127.0.0.1:80 error=\"read tcp: i/o timeout\" empty=\"\"\n"; buf.String() != want {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestConnErrorReporting(t *testing.T) {
+	logger := &recordLogger{}
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	conn.Close()
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := cb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("not disconnected")
+	}
+	cb.Lock()
+	reported := cb.errors[0]
+	serverConn := cb.connected[0]
+	cb.Unlock()
+	ce, ok := reported.(ConnError)
+	if !ok || ce.Conn != serverConn || ce.Phase != "read" || ce.Err != io.EOF || ce.Unwrap() != io.EOF {
+		t.Fatal(reported)
+	}
+	e, ok := logger.find("net error")
+	if !ok || e.level != LevelInfo || e.fields["phase"] != "read" || e.fields["conn"] != serverConn.Identity() ||
+		e.fields["remote"] != serverConn.RemoteAddr() || e.fields["protocol"] != Tcp || e.fields["side"] != "server" {
+		t.Fatal(e)
+	}
+	if _, ok := logger.find("connection opened"); !ok {
+		t.Fatal("connection opened not logged")
+	}
+	if e, ok := logger.find("connection closed"); !ok || e.fields["conn"] != serverConn.Identity() {
+		t.Fatal("connection closed not logged", e)
+	}
+	// 没有来源连接的错误不包装
+	if err := UnwrapConnError(io.EOF); err != io.EOF {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"math"
+	"time"
+)
+
+// 消息, 由Codec负责与网络数据互相转换
+type Message struct {
+	// 消息id, 用于Router分发
+	Id int32
+	// 消息体
+	Payload []byte
+	// 帧类型, 文本帧仅WebSocket支持, 其它协议发送时返回错误
+	// 文本帧不经过Codec, Payload即为帧内容, Id固定为0
+	Type MessageType
+}
+
+// WebSocket帧类型
+type MessageType int
+
+const (
+	// 二进制帧, 默认类型
+	BinaryMessage MessageType = iota
+	// 文本帧
+	TextMessage
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case BinaryMessage:
+		return "binary"
+	case TextMessage:
+		return "text"
+	}
+	return "unknown"
+}
+
+// 保留的消息id, 由框架内部处理, 不会交给回调
+const (
+	// 心跳请求
+	MessageIdPing int32 = math.MinInt32 + iota
+	// 心跳应答
+	MessageIdPong
+	// 服务器踢出连接, 消息体为原因
+	MessageIdKick
+)
+
+// 处理收到的消息, 所有消息包括心跳都先按连接的入站限速处理
+// 心跳请求在此应答, 保留消息不交给回调, 其它消息交给回调
+func dispatch(conn Conn, msg *Message, o *options, callback Callback) {
+	o.metrics.MessageReceived(conn.NetProtocol())
+	if !conn.admit(len(msg.Payload)) {
+		return
+	}
+	switch msg.Id {
+	case MessageIdPing:
+		if err := conn.SendMessage(&Message{Id: MessageIdPong}); err != nil {
+			reportError(o, callback, conn, "heartbeat", err)
+		}
+		return
+	case MessageIdPong:
+		return
+	case MessageIdKick:
+		// 只有服务器可以踢出连接, 服务器收到时视为协议错误并丢弃
+		if o.isServer {
+			reportError(o, callback, conn, "read", InvalidMessageError{"unexpected kick message from client"})
+			return
+		}
+		_ = closeWithReason(conn, KickedError{Reason: string(msg.Payload)})
+		return
+	}
+	if callback == nil {
+		return
+	}
+	start := time.Now()
+	// 只有回调恰好是Router时才直接分发, 嵌入Router的类型可能重写了OnMessage
+	r, ok := callback.(*Router)
+	if !ok {
+		callback.OnMessage(conn, msg)
+		o.metrics.HandlerLatency(conn.NetProtocol(), msg.Id, time.Since(start))
+		return
+	}
+	if err := r.route(conn, msg); err != nil {
+		reportError(o, callback, conn, "handler", err)
+	}
+	// 消息id由对端决定, 未注册的消息id不记录耗时, 避免指标无限增长
+	if r.routed(msg.Id) {
+		o.metrics.HandlerLatency(conn.NetProtocol(), msg.Id, time.Since(start))
+	}
+}
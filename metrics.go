@@ -0,0 +1,286 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 运行指标收集接口, 通过WithMetrics设置, 方法会在收发协程中并发调用, 实现需并发安全且尽量轻量
+type Metrics interface {
+	// 连接建立和断开
+	ConnOpened(Protocol)
+	ConnClosed(Protocol)
+	// 连接因连接限制被拒绝
+	ConnRejected(Protocol)
+	// 从连接读取到n字节数据
+	BytesReceived(p Protocol, n int)
+	// 收到一条完整消息, 包括心跳等保留消息
+	MessageReceived(Protocol)
+	// 一帧n字节的数据写入连接
+	MessageSent(p Protocol, n int)
+	// 发送失败, 包括写入失败和写队列满
+	SendFailed(Protocol)
+	// 收到的数据无法解码或超出长度限制
+	DecodeFailed(Protocol)
+	// 数据进入写队列后队列中的帧数
+	QueueDepth(p Protocol, depth int)
+	// OnMessage处理一条消息的耗时, 回调为Router时只报告已注册处理函数的消息id
+	HandlerLatency(p Protocol, id int32, d time.Duration)
+}
+
+// 默认的指标收集, 不做任何处理
+type nopMetrics struct{}
+
+func (nopMetrics) ConnOpened(Protocol)                           {}
+func (nopMetrics) ConnClosed(Protocol)                           {}
+func (nopMetrics) ConnRejected(Protocol)                         {}
+func (nopMetrics) BytesReceived(Protocol, int)                   {}
+func (nopMetrics) MessageReceived(Protocol)                      {}
+func (nopMetrics) MessageSent(Protocol, int)                     {}
+func (nopMetrics) SendFailed(Protocol)                           {}
+func (nopMetrics) DecodeFailed(Protocol)                         {}
+func (nopMetrics) QueueDepth(Protocol, int)                      {}
+func (nopMetrics) HandlerLatency(Protocol, int32, time.Duration) {}
+
+// 处理耗时的直方图分桶(秒), 与Prometheus客户端的默认分桶一致
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 单个协议最多单独统计处理耗时的消息id数量, 超出的消息id合并统计为other
+const maxLatencyIds = 256
+
+// 写队列长度的直方图分桶
+var queueDepthBuckets = []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// 单个协议的计数器
+type protocolCounters struct {
+	opened, closed, rejected uint64
+	bytesIn, bytesOut        uint64
+	messagesIn, messagesOut  uint64
+	sendErrors, decodeErrors uint64
+	queueDepth               *histogram
+	latency                  map[int32]*histogram
+	otherLatency             *histogram
+	latencyMutex             sync.Mutex
+}
+
+// 累计分桶的直方图
+type histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// 内存中的指标收集, 可输出Prometheus文本格式, 也可直接作为http.Handler提供抓取
+type MemoryMetrics struct {
+	mutex     sync.RWMutex
+	protocols map[Protocol]*protocolCounters
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{protocols: make(map[Protocol]*protocolCounters)}
+}
+
+func (m *MemoryMetrics) counters(p Protocol) *protocolCounters {
+	m.mutex.RLock()
+	c, ok := m.protocols[p]
+	m.mutex.RUnlock()
+	if ok {
+		return c
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok = m.protocols[p]; !ok {
+		c = &protocolCounters{queueDepth: newHistogram(queueDepthBuckets), latency: make(map[int32]*histogram)}
+		m.protocols[p] = c
+	}
+	return c
+}
+
+func (m *MemoryMetrics) ConnOpened(p Protocol) {
+	atomic.AddUint64(&m.counters(p).opened, 1)
+}
+
+func (m *MemoryMetrics) ConnClosed(p Protocol) {
+	atomic.AddUint64(&m.counters(p).closed, 1)
+}
+
+func (m *MemoryMetrics) ConnRejected(p Protocol) {
+	atomic.AddUint64(&m.counters(p).rejected, 1)
+}
+
+func (m *MemoryMetrics) BytesReceived(p Protocol, n int) {
+	atomic.AddUint64(&m.counters(p).bytesIn, uint64(n))
+}
+
+func (m *MemoryMetrics) MessageReceived(p Protocol) {
+	atomic.AddUint64(&m.counters(p).messagesIn, 1)
+}
+
+func (m *MemoryMetrics) MessageSent(p Protocol, n int) {
+	c := m.counters(p)
+	atomic.AddUint64(&c.messagesOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+}
+
+func (m *MemoryMetrics) SendFailed(p Protocol) {
+	atomic.AddUint64(&m.counters(p).sendErrors, 1)
+}
+
+func (m *MemoryMetrics) DecodeFailed(p Protocol) {
+	atomic.AddUint64(&m.counters(p).decodeErrors, 1)
+}
+
+func (m *MemoryMetrics) QueueDepth(p Protocol, depth int) {
+	m.counters(p).queueDepth.observe(float64(depth))
+}
+
+func (m *MemoryMetrics) HandlerLatency(p Protocol, id int32, d time.Duration) {
+	c := m.counters(p)
+	c.latencyMutex.Lock()
+	h, ok := c.latency[id]
+	if !ok {
+		if len(c.latency) < maxLatencyIds {
+			h = newHistogram(latencyBuckets)
+			c.latency[id] = h
+		} else {
+			if c.otherLatency == nil {
+				c.otherLatency = newHistogram(latencyBuckets)
+			}
+			h = c.otherLatency
+		}
+	}
+	c.latencyMutex.Unlock()
+	h.observe(d.Seconds())
+}
+
+// 按协议排序的计数器快照
+func (m *MemoryMetrics) sorted() ([]Protocol, []*protocolCounters) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	protocols := make([]Protocol, 0, len(m.protocols))
+	for p := range m.protocols {
+		protocols = append(protocols, p)
+	}
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i] < protocols[j] })
+	counters := make([]*protocolCounters, len(protocols))
+	for i, p := range protocols {
+		counters[i] = m.protocols[p]
+	}
+	return protocols, counters
+}
+
+// 以Prometheus文本格式输出所有指标
+func (m *MemoryMetrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	protocols, counters := m.sorted()
+	writeCounter := func(name, help string, value func(*protocolCounters) uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for i, p := range protocols {
+			fmt.Fprintf(bw, "%s{protocol=%q} %d\n", name, p.String(), value(counters[i]))
+		}
+	}
+	writeCounter("net_connections_opened_total", "Connections opened.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.opened) })
+	writeCounter("net_connections_closed_total", "Connections closed.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.closed) })
+	writeCounter("net_connections_rejected_total", "Connections rejected by connection limits.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.rejected) })
+	writeCounter("net_received_bytes_total", "Bytes received.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.bytesIn) })
+	writeCounter("net_sent_bytes_total", "Bytes sent.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.bytesOut) })
+	writeCounter("net_received_messages_total", "Messages received.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.messagesIn) })
+	writeCounter("net_sent_messages_total", "Frames sent.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.messagesOut) })
+	writeCounter("net_send_errors_total", "Failed sends.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.sendErrors) })
+	writeCounter("net_decode_errors_total", "Received data that could not be decoded.", func(c *protocolCounters) uint64 { return atomic.LoadUint64(&c.decodeErrors) })
+
+	fmt.Fprintf(bw, "# HELP net_connections_active Connections currently open.\n# TYPE net_connections_active gauge\n")
+	for i, p := range protocols {
+		active := int64(atomic.LoadUint64(&counters[i].opened)) - int64(atomic.LoadUint64(&counters[i].closed))
+		fmt.Fprintf(bw, "net_connections_active{protocol=%q} %d\n", p.String(), active)
+	}
+
+	fmt.Fprintf(bw, "# HELP net_write_queue_depth Write queue depth after enqueue.\n# TYPE net_write_queue_depth histogram\n")
+	for i, p := range protocols {
+		writeHistogram(bw, "net_write_queue_depth", fmt.Sprintf("protocol=%q", p.String()), counters[i].queueDepth)
+	}
+
+	fmt.Fprintf(bw, "# HELP net_handler_duration_seconds Time spent in OnMessage per message id.\n# TYPE net_handler_duration_seconds histogram\n")
+	for i, p := range protocols {
+		c := counters[i]
+		c.latencyMutex.Lock()
+		ids := make([]int32, 0, len(c.latency))
+		for id := range c.latency {
+			ids = append(ids, id)
+		}
+		histograms := make(map[int32]*histogram, len(c.latency))
+		for id, h := range c.latency {
+			histograms[id] = h
+		}
+		other := c.otherLatency
+		c.latencyMutex.Unlock()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			writeHistogram(bw, "net_handler_duration_seconds", fmt.Sprintf("protocol=%q,id=\"%d\"", p.String(), id), histograms[id])
+		}
+		if other != nil {
+			writeHistogram(bw, "net_handler_duration_seconds", fmt.Sprintf("protocol=%q,id=\"other\"", p.String()), other)
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 以Prometheus文本格式响应抓取请求
+func (m *MemoryMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
@@ -0,0 +1,159 @@
+package net
+
+import (
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryMetricsPrometheus(t *testing.T) {
+	m := NewMemoryMetrics()
+	m.ConnOpened(Tcp)
+	m.ConnOpened(Tcp)
+	m.ConnClosed(Tcp)
+	m.ConnRejected(WebSocket)
+	m.MessageSent(Tcp, 10)
+	m.QueueDepth(Tcp, 3)
+	m.HandlerLatency(Tcp, 7, 20*time.Millisecond)
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE net_connections_opened_total counter",
+		`net_connections_opened_total{protocol="tcp"} 2`,
+		`net_connections_active{protocol="tcp"} 1`,
+		`net_connections_rejected_total{protocol="websocket"} 1`,
+		`net_sent_bytes_total{protocol="tcp"} 10`,
+		`net_write_queue_depth_bucket{protocol="tcp",le="2"} 0`,
+		`net_write_queue_depth_bucket{protocol="tcp",le="5"} 1`,
+		`net_handler_duration_seconds_bucket{protocol="tcp",id="7",le="0.01"} 0`,
+		`net_handler_duration_seconds_bucket{protocol="tcp",id="7",le="0.025"} 1`,
+		`net_handler_duration_seconds_bucket{protocol="tcp",id="7",le="+Inf"} 1`,
+		`net_handler_duration_seconds_count{protocol="tcp",id="7"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, out)
+		}
+	}
+	// tcp排在websocket之前
+	if strings.Index(out, `net_connections_opened_total{protocol="tcp"}`) > strings.Index(out, `net_connections_opened_total{protocol="websocket"}`) {
+		t.Fatal("protocols not sorted")
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || rec.Body.String() != out {
+		t.Fatal(rec.Header(), rec.Body.String())
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := NewMemoryMetrics()
+	serverCb := echoCallback()
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	cb := &testCallback{}
+	client, err := Connect(Tcp, server.Addr(), cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SendMessage(&Message{Id: 3, Payload: []byte("hello")})
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("no echo")
+	}
+	client.Close()
+	// 无法解码的数据
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0, 0, 0, 1})
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := serverCb.count()
+		return disconnected == 2
+	}) {
+		t.Fatal("not disconnected")
+	}
+	c := m.counters(Tcp)
+	if !waitFor(time.Second, func() bool {
+		return atomic.LoadUint64(&c.closed) == 2
+	}) {
+		t.Fatal("closed not counted")
+	}
+	if atomic.LoadUint64(&c.opened) != 2 || atomic.LoadUint64(&c.messagesIn) != 1 || atomic.LoadUint64(&c.messagesOut) != 1 || atomic.LoadUint64(&c.decodeErrors) != 1 {
+		t.Fatal(c.opened, c.messagesIn, c.messagesOut, c.decodeErrors)
+	}
+	if atomic.LoadUint64(&c.bytesIn) != atomic.LoadUint64(&c.bytesOut)+4 {
+		t.Fatal(c.bytesIn, c.bytesOut)
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `net_handler_duration_seconds_count{protocol="tcp",id="3"} 1`) {
+		t.Fatal("handler latency not recorded", buf.String())
+	}
+}
+
+func TestMemoryMetricsLatencyCardinality(t *testing.T) {
+	m := NewMemoryMetrics()
+	for id := int32(0); id < maxLatencyIds*4; id++ {
+		m.HandlerLatency(Tcp, id, time.Millisecond)
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	out := buf.String()
+	if n := strings.Count(out, "net_handler_duration_seconds_count{"); n != maxLatencyIds+1 {
+		t.Fatal("series count", n)
+	}
+	if !strings.Contains(out, `net_handler_duration_seconds_count{protocol="tcp",id="other"} 768`+"\n") {
+		t.Fatal("other not recorded", out)
+	}
+}
+
+func TestRouterLatencyUnknownIds(t *testing.T) {
+	m := NewMemoryMetrics()
+	var handled int32
+	router := NewRouter()
+	router.Handle(1, func(conn Conn, msg *Message) {
+		atomic.AddInt32(&handled, 1)
+	})
+	router.Fallback(func(conn Conn, msg *Message) {
+		atomic.AddInt32(&handled, 1)
+	})
+	server, err := Listen(Tcp, 0, router, WithBindAddress("127.0.0.1"), WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for id := int32(1); id <= 100; id++ {
+		client.SendMessage(&Message{Id: id})
+	}
+	if !waitFor(time.Second, func() bool {
+		return atomic.LoadInt32(&handled) == 100
+	}) {
+		t.Fatal("not handled", atomic.LoadInt32(&handled))
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	out := buf.String()
+	// 只有注册了处理函数的消息id产生耗时序列
+	if n := strings.Count(out, "net_handler_duration_seconds_count{"); n != 1 || !strings.Contains(out, `net_handler_duration_seconds_count{protocol="tcp",id="1"} 1`) {
+		t.Fatal(out)
+	}
+}
@@ -14,6 +14,12 @@
 
 package net
 
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
 // 网络协议定义
 type Protocol int
 
@@ -25,7 +31,7 @@ const (
 
 // 消息回调
 type Callback interface {
-	OnMessage(Conn, []byte)
+	OnMessage(Conn, *Message)
 	OnConnected(Conn)
 	OnDisconnected(Conn)
 	OnError(error)
@@ -33,21 +39,59 @@ type Callback interface {
 
 // 服务器接口
 type Server interface {
-	listen(int, Callback) error
+	setup(Server, int, Callback, *options)
+	bind(string) (net.Addr, error)
+	unbind(context.Context) error
+	Start() error
+	Serve(context.Context) error
+	Shutdown(context.Context) error
 	GetConnection(uint32) (Conn, bool)
+	Range(func(Conn) bool)
+	Count() int
+	// 因连接限制被拒绝的连接总数
+	// KCP对端被拒绝后会持续重传, 重传期间的包直接丢弃, 不重复计数和报告
+	Rejected() uint64
+	Conns() []Conn
+	Kick(uint32, string) error
+	Broadcast(*Message) error
+	SendTo([]uint32, *Message) error
+	Join(string, uint32) error
+	Leave(string, uint32)
+	SendGroup(string, *Message) error
+	Addr() string
 	Close() error
 }
 
 // 客户端接口
 type Client interface {
-	connect(string, Callback) error
+	setup(Client, Callback, *options)
+	dial(context.Context, string) (Conn, error)
+	connect(context.Context, string) error
 	Send([]byte) error
+	SendMessage(*Message) error
 	Close() error
 	Reconnect() error
+	// 客户端停止运行时关闭, 包括主动关闭, 连接断开且未开启重连, 或重连次数用尽
+	Done() <-chan struct{}
+	// 停止运行的原因, 主动关闭或仍在运行时为nil
+	Err() error
 }
 
-// 同步执行网络监听
-func Listen(net Protocol, port int, callback Callback) (Server, error) {
+func (p Protocol) String() string {
+	switch p {
+	case Tcp:
+		return "tcp"
+	case WebSocket:
+		return "websocket"
+	case Kcp:
+		return "kcp"
+	default:
+		return "unknown(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// 创建服务器, 调用Start或Serve后开始监听
+func NewServer(net Protocol, port int, callback Callback, opts ...Option) (Server, error) {
 	var server Server
 	switch net {
 	case Tcp:
@@ -59,11 +103,48 @@ func Listen(net Protocol, port int, callback Callback) (Server, error) {
 	default:
 		return nil, &UnknownNetTypeError{UnknownType: int(net)}
 	}
-	return server, server.listen(port, callback)
+	o, err := newOptions(net, true, opts)
+	if err != nil {
+		return nil, err
+	}
+	server.setup(server, port, callback, o)
+	return server, nil
+}
+
+// 创建不监听端口的WebSocket服务器, 通过Handler挂载到自定义的http路由
+// 返回时已可处理请求, 关闭方式与其它服务器相同
+func NewWebSocketHandler(callback Callback, opts ...Option) (WebSocketServer, error) {
+	server, err := NewServer(WebSocket, 0, callback, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ws := server.(*wsServer)
+	ws.detached = true
+	if err := ws.Start(); err != nil {
+		return nil, err
+	}
+	return ws, nil
 }
 
-// 同步连接服务器
-func Connect(net Protocol, serverAddr string, callback Callback) (Client, error) {
+// 监听端口, 绑定完成后立即返回, 连接在后台接收
+func Listen(net Protocol, port int, callback Callback, opts ...Option) (Server, error) {
+	server, err := NewServer(net, port, callback, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// 连接服务器, 连接建立后返回, 消息在后台处理
+func Connect(net Protocol, serverAddr string, callback Callback, opts ...Option) (Client, error) {
+	return ConnectContext(context.Background(), net, serverAddr, callback, opts...)
+}
+
+// 同Connect, ctx用于取消连接或限制连接耗时, 连接建立后ctx不再影响客户端
+func ConnectContext(ctx context.Context, net Protocol, serverAddr string, callback Callback, opts ...Option) (Client, error) {
 	var client Client
 	switch net {
 	case Tcp:
@@ -75,5 +156,13 @@ func Connect(net Protocol, serverAddr string, callback Callback) (Client, error)
 	default:
 		return nil, &UnknownNetTypeError{UnknownType: int(net)}
 	}
-	return client, client.connect(serverAddr, callback)
+	o, err := newOptions(net, false, opts)
+	if err != nil {
+		return nil, err
+	}
+	client.setup(client, callback, o)
+	if err := client.connect(ctx, serverAddr); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
@@ -0,0 +1,738 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// 服务器和客户端的可选配置
+// 协议或使用端不匹配的配置会在Listen/Connect时返回UnsupportedOptionError
+type Option func(*options) error
+
+type options struct {
+	protocol Protocol
+	isServer bool
+
+	codec           Codec
+	readBufferSize  int
+	writeBufferSize int
+	bindAddress     string
+	maxConnections  int
+	// 单个IP的最大连接数, 0为不限制
+	maxConnectionsPerIP int
+	// 单个IP每秒可新建的连接数及突发数, 0为不限制
+	acceptRate       float64
+	acceptBurst      int
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	tlsConfig        *tls.Config
+	shutdownTimeout  time.Duration
+	reconnect        *ReconnectPolicy
+	sendQueueSize    int
+	// 心跳间隔, 0为关闭心跳
+	heartbeatInterval  time.Duration
+	heartbeatMaxMissed int
+	wsPath             string
+	checkOrigin        func(r *http.Request) bool
+	upgradeCheck       func(r *http.Request) error
+	subprotocols       []string
+	requestHeader      http.Header
+	kcp                *KcpOptions
+	kcpCipher          string
+	kcpKey             string
+	writeQueueSize     int
+	writeQueuePolicy   OverflowPolicy
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	idleTimeout        time.Duration
+	maxMessageSize     int
+	rateLimit          RateLimit
+	metrics            Metrics
+	logger             Logger
+}
+
+// 默认的接收消息最大长度, 避免对端声明超大长度耗尽内存
+const defaultMaxMessageSize = 4 << 20 // 4MB
+
+func newOptions(protocol Protocol, isServer bool, opts []Option) (*options, error) {
+	o := &options{
+		protocol:       protocol,
+		isServer:       isServer,
+		codec:          defaultCodec(),
+		readBufferSize: 4096, // 4KB
+		// 与websocket.DefaultDialer一致
+		handshakeTimeout: 45 * time.Second,
+		shutdownTimeout:  5 * time.Second,
+		wsPath:           "/",
+		maxMessageSize:   defaultMaxMessageSize,
+		metrics:          nopMetrics{},
+		logger:           nopLogger{},
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.heartbeatInterval > 0 && !o.isServer && o.protocol != WebSocket {
+		if _, ok := o.codec.(*rawCodec); ok {
+			return nil, InvalidOptionError{"heartbeat requires a codec with message id"}
+		}
+	}
+	return o, nil
+}
+
+// 限定配置可用的协议
+func (o *options) requireProtocol(option string, protocols ...Protocol) error {
+	for _, p := range protocols {
+		if o.protocol == p {
+			return nil
+		}
+	}
+	return UnsupportedOptionError{Option: option, Protocol: o.protocol}
+}
+
+// 限定配置仅用于服务器
+func (o *options) requireServer(option string) error {
+	if !o.isServer {
+		return UnsupportedOptionError{Option: option, Protocol: o.protocol, Side: "client"}
+	}
+	return nil
+}
+
+// 限定配置仅用于客户端
+func (o *options) requireClient(option string) error {
+	if o.isServer {
+		return UnsupportedOptionError{Option: option, Protocol: o.protocol, Side: "server"}
+	}
+	return nil
+}
+
+// 消息编解码器, 默认为4字节长度+4字节消息id的定长包头格式
+func WithCodec(codec Codec) Option {
+	return func(o *options) error {
+		if codec == nil {
+			return InvalidOptionError{"codec is nil"}
+		}
+		o.codec = codec
+		return nil
+	}
+}
+
+// 读缓冲区大小, 默认4KB
+// TCP/KCP为每次读取的字节数, WebSocket为底层连接的读缓冲
+func WithReadBufferSize(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return InvalidOptionError{"read buffer size must be positive"}
+		}
+		o.readBufferSize = size
+		return nil
+	}
+}
+
+// WebSocket写缓冲区大小
+func WithWriteBufferSize(size int) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithWriteBufferSize", WebSocket); err != nil {
+			return err
+		}
+		if size <= 0 {
+			return InvalidOptionError{"write buffer size must be positive"}
+		}
+		o.writeBufferSize = size
+		return nil
+	}
+}
+
+// 服务器绑定的地址, 默认监听所有网卡
+func WithBindAddress(host string) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithBindAddress"); err != nil {
+			return err
+		}
+		o.bindAddress = host
+		return nil
+	}
+}
+
+// 服务器最大连接数, 超出时拒绝新连接, 0为不限制
+func WithMaxConnections(max int) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithMaxConnections"); err != nil {
+			return err
+		}
+		if max < 0 {
+			return InvalidOptionError{"max connections must not be negative"}
+		}
+		o.maxConnections = max
+		return nil
+	}
+}
+
+// 单个IP的最大连接数, 超出时拒绝新连接, 0为不限制
+func WithMaxConnectionsPerIP(max int) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithMaxConnectionsPerIP"); err != nil {
+			return err
+		}
+		if max < 0 {
+			return InvalidOptionError{"max connections per ip must not be negative"}
+		}
+		o.maxConnectionsPerIP = max
+		return nil
+	}
+}
+
+// 单个IP每秒可新建rate个连接, 最多允许burst个连接同时到达, 超出时拒绝新连接
+// rate为0不限制, burst小于1时取1
+func WithAcceptRate(rate float64, burst int) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithAcceptRate"); err != nil {
+			return err
+		}
+		if rate < 0 {
+			return InvalidOptionError{"accept rate must not be negative"}
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		o.acceptRate = rate
+		o.acceptBurst = burst
+		return nil
+	}
+}
+
+// 客户端连接超时, KCP基于UDP无连接过程, 不支持该配置
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithDialTimeout", Tcp, WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireClient("WithDialTimeout"); err != nil {
+			return err
+		}
+		o.dialTimeout = timeout
+		return nil
+	}
+}
+
+// WebSocket及TLS握手超时, 默认45秒
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithHandshakeTimeout", Tcp, WebSocket); err != nil {
+			return err
+		}
+		o.handshakeTimeout = timeout
+		return nil
+	}
+}
+
+// TLS配置, 仅支持TCP和WebSocket
+// 传入的配置会被复制, 之后的TLS相关配置不会修改原对象; 放在其它TLS配置之后会覆盖它们
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSConfig", Tcp, WebSocket); err != nil {
+			return err
+		}
+		if config == nil {
+			return InvalidOptionError{"tls config is nil"}
+		}
+		o.tlsConfig = config.Clone()
+		return nil
+	}
+}
+
+// 获取TLS配置, 不存在时创建
+func (o *options) tls() *tls.Config {
+	if o.tlsConfig == nil {
+		o.tlsConfig = &tls.Config{}
+	}
+	return o.tlsConfig
+}
+
+// TLS证书, 服务器为服务端证书, 客户端为双向认证时出示的客户端证书
+// 服务器可多次设置, 按客户端SNI选择匹配的证书
+func WithTLSCertificate(cert tls.Certificate) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSCertificate", Tcp, WebSocket); err != nil {
+			return err
+		}
+		config := o.tls()
+		config.Certificates = append(config.Certificates, cert)
+		return nil
+	}
+}
+
+// 从PEM文件加载TLS证书和私钥, 用法同WithTLSCertificate
+func WithTLSCertFile(certFile, keyFile string) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSCertFile", Tcp, WebSocket); err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return InvalidOptionError{"load tls certificate: " + err.Error()}
+		}
+		config := o.tls()
+		config.Certificates = append(config.Certificates, cert)
+		return nil
+	}
+}
+
+// 开启双向认证, 服务器要求客户端出示由clientCAs签发的证书
+func WithTLSClientAuth(clientCAs *x509.CertPool) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSClientAuth", Tcp, WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireServer("WithTLSClientAuth"); err != nil {
+			return err
+		}
+		if clientCAs == nil {
+			return InvalidOptionError{"client CA pool is nil"}
+		}
+		config := o.tls()
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		return nil
+	}
+}
+
+// 客户端校验服务器证书使用的根证书, 默认使用系统根证书
+func WithTLSRootCAs(rootCAs *x509.CertPool) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSRootCAs", Tcp, WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireClient("WithTLSRootCAs"); err != nil {
+			return err
+		}
+		o.tls().RootCAs = rootCAs
+		return nil
+	}
+}
+
+// 客户端SNI及校验服务器证书使用的主机名, 默认取连接地址中的主机名
+func WithTLSServerName(name string) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithTLSServerName", Tcp, WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireClient("WithTLSServerName"); err != nil {
+			return err
+		}
+		o.tls().ServerName = name
+		return nil
+	}
+}
+
+// Serve在ctx结束后优雅关闭的最长等待时间, 默认5秒
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithShutdownTimeout"); err != nil {
+			return err
+		}
+		if timeout <= 0 {
+			return InvalidOptionError{"shutdown timeout must be positive"}
+		}
+		o.shutdownTimeout = timeout
+		return nil
+	}
+}
+
+// 断线重连策略, 重连间隔按指数退避增长
+type ReconnectPolicy struct {
+	// 首次重连间隔, 默认500毫秒
+	InitialDelay time.Duration
+	// 最大重连间隔, 默认30秒
+	MaxDelay time.Duration
+	// 间隔增长倍数, 默认2
+	Multiplier float64
+	// 随机抖动比例(0~1), 实际间隔在[delay*(1-Jitter), delay*(1+Jitter)]之间, 0为不抖动
+	Jitter float64
+	// 最大重连次数, 0为不限制
+	MaxAttempts int
+}
+
+// 默认重连策略, 不限次数
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// 第attempt次重连前的等待时间
+func (p *ReconnectPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	return time.Duration(d)
+}
+
+// 开启客户端断线重连, 未设置的间隔和倍数使用默认值
+// Connect在连接建立后即返回, 断开后由后台按策略重连, 首次连接失败时同样重试
+// 客户端关闭或重连次数用尽后停止, Done返回的通道关闭, Err返回停止原因
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(o *options) error {
+		if err := o.requireClient("WithReconnect"); err != nil {
+			return err
+		}
+		if policy.InitialDelay <= 0 {
+			policy.InitialDelay = 500 * time.Millisecond
+		}
+		if policy.MaxDelay <= 0 {
+			policy.MaxDelay = 30 * time.Second
+		}
+		if policy.MaxDelay < policy.InitialDelay {
+			policy.MaxDelay = policy.InitialDelay
+		}
+		if policy.Multiplier < 1 {
+			policy.Multiplier = 2
+		}
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return InvalidOptionError{"reconnect jitter must be between 0 and 1"}
+		}
+		o.reconnect = &policy
+		return nil
+	}
+}
+
+// 客户端断线期间缓存Send数据的最大条数, 重连成功后按顺序发送, 0为不缓存
+func WithSendQueue(size int) Option {
+	return func(o *options) error {
+		if err := o.requireClient("WithSendQueue"); err != nil {
+			return err
+		}
+		if size < 0 {
+			return InvalidOptionError{"send queue size must not be negative"}
+		}
+		o.sendQueueSize = size
+		return nil
+	}
+}
+
+// 开启心跳, 客户端每隔interval发送一次心跳
+// 连续maxMissed个间隔未收到任何数据的连接会被关闭, 关闭原因为HeartbeatTimeoutError
+// TCP/KCP使用保留的消息id, WebSocket客户端使用原生ping帧
+func WithHeartbeat(interval time.Duration, maxMissed int) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return InvalidOptionError{"heartbeat interval must be positive"}
+		}
+		if maxMissed <= 0 {
+			return InvalidOptionError{"heartbeat max missed must be positive"}
+		}
+		o.heartbeatInterval = interval
+		o.heartbeatMaxMissed = maxMissed
+		return nil
+	}
+}
+
+// WebSocket服务器监听的路径, 默认为"/", 其它路径返回404
+// 以"/"结尾时匹配该前缀下的所有路径, 同http.ServeMux
+func WithPath(path string) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithPath", WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireServer("WithPath"); err != nil {
+			return err
+		}
+		if len(path) == 0 || path[0] != '/' {
+			return InvalidOptionError{"websocket path must begin with '/'"}
+		}
+		o.wsPath = path
+		return nil
+	}
+}
+
+// WebSocket服务器的跨域检查, 返回false拒绝升级
+// 默认只允许Origin与Host相同或不带Origin的请求
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithCheckOrigin", WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireServer("WithCheckOrigin"); err != nil {
+			return err
+		}
+		o.checkOrigin = check
+		return nil
+	}
+}
+
+// WebSocket服务器升级前的检查, 可根据请求头, 参数或cookie进行鉴权
+// 返回UpgradeRejectedError时使用其中的状态码拒绝, 返回其它错误时以403拒绝
+func WithUpgradeCheck(check func(r *http.Request) error) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithUpgradeCheck", WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireServer("WithUpgradeCheck"); err != nil {
+			return err
+		}
+		o.upgradeCheck = check
+		return nil
+	}
+}
+
+// WebSocket支持的子协议
+// 服务器按顺序选择第一个客户端也支持的子协议, 客户端按优先级声明
+func WithSubprotocols(protocols ...string) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithSubprotocols", WebSocket); err != nil {
+			return err
+		}
+		o.subprotocols = protocols
+		return nil
+	}
+}
+
+// WebSocket客户端握手时附加的请求头, 如Authorization, Cookie
+func WithRequestHeader(header http.Header) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithRequestHeader", WebSocket); err != nil {
+			return err
+		}
+		if err := o.requireClient("WithRequestHeader"); err != nil {
+			return err
+		}
+		o.requestHeader = header
+		return nil
+	}
+}
+
+// KCP会话参数, 为0的字段使用kcp-go的默认值
+type KcpOptions struct {
+	// 是否启用nodelay模式
+	NoDelay bool
+	// 内部更新间隔(毫秒), 默认100
+	Interval int
+	// 快速重传触发的跳过ack次数, 0为关闭快速重传
+	Resend int
+	// 是否关闭拥塞控制
+	NoCongestion bool
+	// 发送窗口大小(包), 默认32
+	SndWnd int
+	// 接收窗口大小(包), 默认32
+	RcvWnd int
+	// 最大传输单元, 默认1400
+	Mtu int
+	// 收到数据后立即回复ack
+	AckNoDelay bool
+	// 流模式, 合并小包发送
+	StreamMode bool
+	// Reed-Solomon前向纠错的数据分片和校验分片数, 均为0时关闭
+	DataShards   int
+	ParityShards int
+	// UDP数据包的DSCP标记
+	DSCP int
+	// UDP socket读写缓冲区大小(字节)
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// KCP预设模式, 由normal到fast3延迟依次降低, 带宽消耗依次增加
+func KcpNormal() KcpOptions {
+	return KcpOptions{Interval: 40, Resend: 2, NoCongestion: true}
+}
+
+func KcpFast() KcpOptions {
+	return KcpOptions{Interval: 30, Resend: 2, NoCongestion: true}
+}
+
+func KcpFast2() KcpOptions {
+	return KcpOptions{NoDelay: true, Interval: 20, Resend: 2, NoCongestion: true}
+}
+
+func KcpFast3() KcpOptions {
+	return KcpOptions{NoDelay: true, Interval: 10, Resend: 2, NoCongestion: true}
+}
+
+// KCP会话参数, 服务器对每个接入的会话生效, 客户端对每次连接生效
+func WithKcpOptions(kcpOptions KcpOptions) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithKcpOptions", Kcp); err != nil {
+			return err
+		}
+		if kcpOptions.Interval < 0 || kcpOptions.Resend < 0 || kcpOptions.SndWnd < 0 || kcpOptions.RcvWnd < 0 {
+			return InvalidOptionError{"kcp interval, resend and window sizes must not be negative"}
+		}
+		if kcpOptions.Mtu != 0 && (kcpOptions.Mtu < 50 || kcpOptions.Mtu > 65535) {
+			return InvalidOptionError{"kcp mtu must be between 50 and 65535"}
+		}
+		if kcpOptions.DataShards < 0 || kcpOptions.ParityShards < 0 {
+			return InvalidOptionError{"kcp fec shards must not be negative"}
+		}
+		if (kcpOptions.DataShards == 0) != (kcpOptions.ParityShards == 0) {
+			return InvalidOptionError{"kcp fec requires both data and parity shards"}
+		}
+		if kcpOptions.DSCP < 0 || kcpOptions.DSCP > 63 {
+			return InvalidOptionError{"kcp dscp must be between 0 and 63"}
+		}
+		if kcpOptions.ReadBuffer < 0 || kcpOptions.WriteBuffer < 0 {
+			return InvalidOptionError{"kcp socket buffer sizes must not be negative"}
+		}
+		o.kcp = &kcpOptions
+		return nil
+	}
+}
+
+// KCP数据包加密, 服务器和客户端需使用相同的算法和密钥
+// 实际密钥由key经PBKDF2派生, 支持的算法见kcpCiphers
+func WithKcpCrypt(cipher, key string) Option {
+	return func(o *options) error {
+		if err := o.requireProtocol("WithKcpCrypt", Kcp); err != nil {
+			return err
+		}
+		if _, ok := kcpCiphers[cipher]; !ok {
+			return InvalidOptionError{"unknown kcp cipher " + cipher}
+		}
+		if key == "" && cipher != "none" {
+			return InvalidOptionError{"kcp crypt key must not be empty"}
+		}
+		o.kcpCipher = cipher
+		o.kcpKey = key
+		return nil
+	}
+}
+
+// 写队列满时的处理策略
+type OverflowPolicy int
+
+const (
+	// 阻塞发送者直到队列有空位
+	OverflowBlock OverflowPolicy = iota
+	// 丢弃新数据, 发送返回WriteQueueFullError
+	OverflowDropNewest
+	// 丢弃队列中最早的数据, 被丢弃数据的SendAsync收到WriteQueueFullError
+	OverflowDropOldest
+	// 关闭连接, 关闭原因为WriteQueueFullError
+	OverflowDisconnect
+)
+
+// 开启连接的异步写队列, 由独立协程按顺序发送, 慢速连接不会阻塞发送者
+// size为队列可容纳的帧数, 0为关闭写队列, 在调用者协程中同步发送
+func WithWriteQueue(size int, policy OverflowPolicy) Option {
+	return func(o *options) error {
+		if size < 0 {
+			return InvalidOptionError{"write queue size must not be negative"}
+		}
+		if policy < OverflowBlock || policy > OverflowDisconnect {
+			return InvalidOptionError{"unknown write queue overflow policy"}
+		}
+		o.writeQueueSize = size
+		o.writeQueuePolicy = policy
+		return nil
+	}
+}
+
+// 读超时, 超过该时间未读到数据时连接以TimeoutError关闭, 0为不限制
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return InvalidOptionError{"read timeout must not be negative"}
+		}
+		o.readTimeout = timeout
+		return nil
+	}
+}
+
+// 写超时, 单次写入超过该时间未完成时连接以TimeoutError关闭, 0为不限制
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return InvalidOptionError{"write timeout must not be negative"}
+		}
+		o.writeTimeout = timeout
+		return nil
+	}
+}
+
+// 空闲超时, 超过该时间没有收发任何数据时关闭连接, 0为不限制
+// 与心跳不同, 心跳数据同样计入收发, 只检测应用层是否长时间无交互需关闭心跳
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return InvalidOptionError{"idle timeout must not be negative"}
+		}
+		o.idleTimeout = timeout
+		return nil
+	}
+}
+
+// 接收消息的最大长度(字节), 包含编解码器的包头, 默认4MB, 显式设置为0时不限制
+// 收到超长消息时关闭连接, 并以InvalidMessageLengthError报告
+func WithMaxMessageSize(size int) Option {
+	return func(o *options) error {
+		if size < 0 {
+			return InvalidOptionError{"max message size must not be negative"}
+		}
+		o.maxMessageSize = size
+		return nil
+	}
+}
+
+// 服务器每个连接的入站限速, 可在连接建立后通过Conn.SetRateLimit单独修改
+func WithRateLimit(limit RateLimit) Option {
+	return func(o *options) error {
+		if err := o.requireServer("WithRateLimit"); err != nil {
+			return err
+		}
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		o.rateLimit = limit
+		return nil
+	}
+}
+
+// 运行指标收集, 如NewMemoryMetrics, 多个服务器和客户端可共用同一个实例
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) error {
+		if metrics == nil {
+			metrics = nopMetrics{}
+		}
+		o.metrics = metrics
+		return nil
+	}
+}
+
+// 结构化日志, 如NewStdLogger, 连接建立, 断开和各阶段的错误都会写入日志
+func WithLogger(logger Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		o.logger = logger
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package net
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	o, err := newOptions(Tcp, true, []Option{
+		WithCodec(NewVarintCodec()),
+		WithReadBufferSize(1024),
+		WithBindAddress("127.0.0.1"),
+		WithMaxConnections(10),
+		WithTLSConfig(&tls.Config{}),
+		WithMetrics(nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.readBufferSize != 1024 || o.bindAddress != "127.0.0.1" || o.maxConnections != 10 || o.tlsConfig == nil {
+		t.Fail()
+	}
+	if _, ok := o.codec.(*varintCodec); !ok {
+		t.Fail()
+	}
+	if _, ok := o.metrics.(nopMetrics); !ok {
+		t.Fail()
+	}
+}
+
+func TestOptionsWrongProtocol(t *testing.T) {
+	cases := []struct {
+		protocol Protocol
+		isServer bool
+		option   Option
+	}{
+		{Kcp, true, WithTLSConfig(&tls.Config{})},
+		{Tcp, true, WithWriteBufferSize(1024)},
+		{Kcp, false, WithDialTimeout(time.Second)},
+		{Kcp, false, WithHandshakeTimeout(time.Second)},
+		{Tcp, false, WithBindAddress("127.0.0.1")},
+		{WebSocket, true, WithDialTimeout(time.Second)},
+		{Tcp, true, WithKcpOptions(KcpFast())},
+		{WebSocket, false, WithKcpCrypt("aes", "secret")},
+		{Tcp, false, WithMaxConnectionsPerIP(1)},
+		{Kcp, false, WithAcceptRate(1, 1)},
+		{WebSocket, false, WithRateLimit(RateLimit{Messages: 1})},
+	}
+	for i, c := range cases {
+		_, err := newOptions(c.protocol, c.isServer, []Option{c.option})
+		if _, ok := err.(UnsupportedOptionError); !ok {
+			t.Errorf("case %d: expect UnsupportedOptionError, got %v", i, err)
+		}
+	}
+}
+
+func TestListenUnsupportedOption(t *testing.T) {
+	if _, err := Listen(Kcp, 0, nil, WithTLSConfig(&tls.Config{})); err == nil {
+		t.Fail()
+	} else {
+		t.Log(err)
+	}
+}
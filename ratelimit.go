@@ -0,0 +1,178 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 入站消息超出限速时的处理策略
+type RateLimitPolicy int
+
+const (
+	// 暂停读取直到令牌足够, 对端的发送会被TCP流控阻塞
+	RateLimitDelay RateLimitPolicy = iota
+	// 丢弃超出限速的消息
+	RateLimitDrop
+	// 关闭连接, 关闭原因为RateLimitedError
+	RateLimitDisconnect
+)
+
+// 连接的入站限速, 统计收到的所有消息, 心跳等保留消息同样受限制
+type RateLimit struct {
+	// 每秒消息数, 0为不限制
+	Messages float64
+	// 每秒消息体字节数, 0为不限制
+	Bytes float64
+	// 最多累积的消息数和字节数, 小于1时取每秒的速率
+	MessageBurst int
+	ByteBurst    int
+	Policy       RateLimitPolicy
+}
+
+func (l RateLimit) validate() error {
+	if l.Messages < 0 || l.Bytes < 0 {
+		return InvalidOptionError{"rate limit must not be negative"}
+	}
+	if l.Policy < RateLimitDelay || l.Policy > RateLimitDisconnect {
+		return InvalidOptionError{"unknown rate limit policy"}
+	}
+	return nil
+}
+
+func rateBurst(burst int, rate float64) int {
+	if burst >= 1 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
+
+// 计算令牌桶凑够n个令牌需要等待的时间, 不取走令牌
+func (b *tokenBucket) delay(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// 连接的入站限速状态
+type rateLimiter struct {
+	mutex    sync.Mutex
+	limit    RateLimit
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func (l *rateLimiter) set(limit RateLimit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.limit = limit
+	l.messages, l.bytes = nil, nil
+	if limit.Messages > 0 {
+		l.messages = newTokenBucket(limit.Messages, rateBurst(limit.MessageBurst, limit.Messages), now)
+	}
+	if limit.Bytes > 0 {
+		l.bytes = newTokenBucket(limit.Bytes, rateBurst(limit.ByteBurst, limit.Bytes), now)
+	}
+}
+
+func (l *rateLimiter) get() RateLimit {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// 为size字节的消息取走令牌
+// 令牌不足时不取走任何令牌, 返回需等待的时间, 当前策略及超出的限制
+// 超过突发上限的消息按突发上限计算, 令牌补满后即可通过
+func (l *rateLimiter) reserve(size int) (time.Duration, RateLimitPolicy, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	var err error
+	if l.messages != nil {
+		if d := l.messages.delay(1, now); d > 0 {
+			wait, err = d, RateLimitedError{Limit: "messages", Rate: l.limit.Messages}
+		}
+	}
+	n := float64(size)
+	if l.bytes != nil {
+		n = math.Min(n, l.bytes.burst)
+		if d := l.bytes.delay(n, now); d > wait {
+			wait, err = d, RateLimitedError{Limit: "bytes", Rate: l.limit.Bytes}
+		}
+	}
+	if err != nil {
+		return wait, l.limit.Policy, err
+	}
+	if l.messages != nil {
+		l.messages.take(1, now)
+	}
+	if l.bytes != nil {
+		l.bytes.take(n, now)
+	}
+	return 0, l.limit.Policy, nil
+}
+
+// 修改连接的入站限速, 如在认证后放宽限制, 零值为不限制
+func (c *baseConn) SetRateLimit(limit RateLimit) error {
+	if err := limit.validate(); err != nil {
+		return err
+	}
+	c.limiter.set(limit)
+	return nil
+}
+
+func (c *baseConn) RateLimit() RateLimit {
+	return c.limiter.get()
+}
+
+// 按入站限速检查一条消息, 返回false时不交给回调
+// 延迟策略在读取协程中等待, 连接关闭或停止读取时立即结束等待并丢弃消息
+// 断开策略以RateLimitedError关闭连接
+func (c *baseConn) admit(size int) bool {
+	for {
+		wait, policy, err := c.limiter.reserve(size)
+		if err == nil {
+			return true
+		}
+		switch policy {
+		case RateLimitDrop:
+			return false
+		case RateLimitDisconnect:
+			_ = closeWithReason(c.impl, err)
+			return false
+		}
+		if c.readStopped() {
+			return false
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-c.readStop:
+			timer.Stop()
+			return false
+		}
+		// 等待期间连接可能已关闭
+		if c.readStopped() {
+			return false
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package net
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	var l rateLimiter
+	l.set(RateLimit{Messages: 10, MessageBurst: 2, Bytes: 100, Policy: RateLimitDrop})
+	for i := 0; i < 2; i++ {
+		if _, _, err := l.reserve(10); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	wait, policy, err := l.reserve(10)
+	if err != (RateLimitedError{Limit: "messages", Rate: 10}) || policy != RateLimitDrop || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatal(wait, policy, err)
+	}
+	// 超过突发上限的消息在令牌补满后可以通过
+	l.set(RateLimit{Bytes: 100})
+	if _, _, err := l.reserve(1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.reserve(1); err != (RateLimitedError{Limit: "bytes", Rate: 100}) {
+		t.Fatal(err)
+	}
+	if err := (RateLimit{Policy: RateLimitDisconnect + 1}).validate(); err == nil {
+		t.Fatal("unknown policy accepted")
+	}
+}
+
+// 连接服务器并连续发送count条消息
+func floodServer(t *testing.T, addr string, count int) Client {
+	client, err := Connect(Tcp, addr, &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := client.SendMessage(&Message{Id: int32(i), Payload: []byte("flood")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Messages: 1, MessageBurst: 2, Policy: RateLimitDrop}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := floodServer(t, server.Addr(), 5)
+	time.Sleep(100 * time.Millisecond)
+	if messages, _, _ := cb.count(); messages != 2 {
+		t.Fatal("drop", messages)
+	}
+	client.Close()
+	server.Close()
+
+	cb = &testCallback{}
+	server, err = Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Messages: 20, MessageBurst: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	client = floodServer(t, server.Addr(), 5)
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 5
+	}) {
+		t.Fatal("delayed messages not delivered")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatal("delay", elapsed)
+	}
+	client.Close()
+	server.Close()
+
+	cb = &testCallback{}
+	server, err = Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Bytes: 8, Policy: RateLimitDisconnect}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client = floodServer(t, server.Addr(), 5)
+	defer client.Close()
+	if err := firstError(t, cb, time.Second); err != (RateLimitedError{Limit: "bytes", Rate: 8}) {
+		t.Fatal(err)
+	}
+	if messages, _, _ := cb.count(); messages != 1 {
+		t.Fatal("disconnect", messages)
+	}
+}
+
+func TestRateLimitOverride(t *testing.T) {
+	cb := &testCallback{}
+	cb.handler = func(conn Conn, msg *Message) {
+		// 认证后取消限速
+		if string(msg.Payload) == "auth" {
+			conn.SetRateLimit(RateLimit{})
+		}
+	}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Messages: 1, MessageBurst: 1, Policy: RateLimitDrop}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SendMessage(&Message{Id: 1, Payload: []byte("auth")})
+	for i := 0; i < 5; i++ {
+		client.SendMessage(&Message{Id: 2, Payload: []byte("data")})
+	}
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 6
+	}) {
+		messages, _, _ := cb.count()
+		t.Fatal(messages)
+	}
+	cb.Lock()
+	conn := cb.connected[0]
+	cb.Unlock()
+	if conn.RateLimit() != (RateLimit{}) {
+		t.Fatal(conn.RateLimit())
+	}
+	if err := conn.SetRateLimit(RateLimit{Messages: -1}); err == nil {
+		t.Fatal("negative rate accepted")
+	}
+}
+
+func TestRateLimitHeartbeat(t *testing.T) {
+	server, err := Listen(Tcp, 0, &testCallback{}, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Messages: 1, MessageBurst: 2, Policy: RateLimitDrop}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ping, _ := NewLengthCodec().Encode(&Message{Id: MessageIdPing})
+	for i := 0; i < 10; i++ {
+		conn.Write(ping)
+	}
+	// 超出限速的心跳请求不应答
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	received := 0
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		received += n
+		if err != nil {
+			break
+		}
+	}
+	if received != 2*len(ping) {
+		t.Fatal("pongs", received/len(ping))
+	}
+}
+
+func TestRateLimitDelayInterrupted(t *testing.T) {
+	cb := &testCallback{}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"),
+		WithRateLimit(RateLimit{Messages: 0.1, MessageBurst: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := floodServer(t, server.Addr(), 3)
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("first message not delivered")
+	}
+	// 读取协程正在等待令牌, 关闭时应立即结束等待
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _, disconnected := cb.count(); messages != 1 || disconnected != 1 {
+		t.Fatal("shutdown", messages, disconnected)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 消息处理函数
+type HandlerFunc func(conn Conn, msg *Message)
+
+// 消息处理中间件, 包装下一个处理函数
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// 按消息id分发的消息路由, 可直接作为Callback使用
+// 未注册的消息id交给fallback处理, 未设置fallback时通过OnError报告
+// 处理函数中的panic会被恢复并通过OnError报告, 不影响连接的读取
+type Router struct {
+	sync.RWMutex
+	handlers    map[int32]HandlerFunc
+	fallback    HandlerFunc
+	middlewares []Middleware
+	// 包装了全局中间件的处理函数, 注册处理函数和添加中间件时构建
+	routes        map[int32]HandlerFunc
+	fallbackRoute HandlerFunc
+	connected     func(Conn)
+	disconnected  func(Conn)
+	errorHandler  func(error)
+}
+
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[int32]HandlerFunc),
+		routes:   make(map[int32]HandlerFunc),
+	}
+}
+
+// 注册消息处理函数, middlewares仅作用于该消息id
+func (r *Router) Handle(id int32, handler HandlerFunc, middlewares ...Middleware) {
+	r.Lock()
+	defer r.Unlock()
+	r.handlers[id] = chain(handler, middlewares)
+	r.routes[id] = chain(r.handlers[id], r.middlewares)
+}
+
+// 注销消息处理函数
+func (r *Router) Remove(id int32) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.handlers, id)
+	delete(r.routes, id)
+}
+
+// 设置未注册消息id的处理函数
+func (r *Router) Fallback(handler HandlerFunc, middlewares ...Middleware) {
+	r.Lock()
+	defer r.Unlock()
+	if handler == nil {
+		r.fallback = nil
+		r.fallbackRoute = nil
+	} else {
+		r.fallback = chain(handler, middlewares)
+		r.fallbackRoute = chain(r.fallback, r.middlewares)
+	}
+}
+
+// 添加全局中间件, 先添加的位于外层
+func (r *Router) Use(middlewares ...Middleware) {
+	r.Lock()
+	defer r.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+	// 重新构建所有处理函数的中间件链
+	for id, handler := range r.handlers {
+		r.routes[id] = chain(handler, r.middlewares)
+	}
+	if r.fallback != nil {
+		r.fallbackRoute = chain(r.fallback, r.middlewares)
+	}
+}
+
+func (r *Router) HandleConnected(fn func(Conn)) {
+	r.Lock()
+	defer r.Unlock()
+	r.connected = fn
+}
+
+func (r *Router) HandleDisconnected(fn func(Conn)) {
+	r.Lock()
+	defer r.Unlock()
+	r.disconnected = fn
+}
+
+func (r *Router) HandleError(fn func(error)) {
+	r.Lock()
+	defer r.Unlock()
+	r.errorHandler = fn
+}
+
+// 处理错误通过OnError报告, conn不为nil时包装为ConnError, 阶段为handler
+func (r *Router) OnMessage(conn Conn, msg *Message) {
+	if err := r.route(conn, msg); err != nil {
+		if conn != nil {
+			err = ConnError{Conn: conn, Phase: "handler", Err: err}
+		}
+		r.OnError(err)
+	}
+}
+
+// 将消息交给对应的处理函数, 返回未注册消息id或处理函数panic的错误
+func (r *Router) route(conn Conn, msg *Message) (err error) {
+	r.RLock()
+	handler, ok := r.routes[msg.Id]
+	if !ok {
+		handler = r.fallbackRoute
+	}
+	r.RUnlock()
+	if handler == nil {
+		return UnknownMessageError{msg.Id}
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = HandlerPanicError{Id: msg.Id, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	handler(conn, msg)
+	return nil
+}
+
+// 消息id是否注册了处理函数, 由fallback处理的消息id不算在内
+func (r *Router) routed(id int32) bool {
+	r.RLock()
+	defer r.RUnlock()
+	_, ok := r.handlers[id]
+	return ok
+}
+
+func (r *Router) OnConnected(conn Conn) {
+	r.RLock()
+	fn := r.connected
+	r.RUnlock()
+	if fn != nil {
+		fn(conn)
+	}
+}
+
+func (r *Router) OnDisconnected(conn Conn) {
+	r.RLock()
+	fn := r.disconnected
+	r.RUnlock()
+	if fn != nil {
+		fn(conn)
+	}
+}
+
+func (r *Router) OnError(err error) {
+	r.RLock()
+	fn := r.errorHandler
+	r.RUnlock()
+	if fn != nil {
+		fn(err)
+	}
+}
+
+// 将中间件按顺序包装到处理函数外, middlewares[0]位于最外层
+func chain(handler HandlerFunc, middlewares []Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// 记录每条消息处理耗时的中间件, 以Debug级别输出, logger为nil时使用标准库默认logger
+func LoggingMiddleware(logger Logger) Middleware {
+	if logger == nil {
+		logger = NewStdLogger(nil, LevelDebug)
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(conn Conn, msg *Message) {
+			start := time.Now()
+			next(conn, msg)
+			logger.Log(LevelDebug, "message handled", "id", msg.Id, "remote", remoteAddrOf(conn), "elapsed", time.Since(start))
+		}
+	}
+}
+
+// 在处理函数执行前进行校验的中间件, check返回false时丢弃消息
+func FilterMiddleware(check func(Conn, *Message) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(conn Conn, msg *Message) {
+			if check(conn, msg) {
+				next(conn, msg)
+			}
+		}
+	}
+}
+
+func remoteAddrOf(conn Conn) string {
+	if conn == nil {
+		return "-"
+	}
+	return conn.RemoteAddr()
+}
@@ -0,0 +1,236 @@
+package net
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	var got []int32
+	r.Handle(1, func(conn Conn, msg *Message) {
+		got = append(got, msg.Id)
+	})
+	r.Handle(2, func(conn Conn, msg *Message) {
+		got = append(got, msg.Id*10)
+	})
+	r.OnMessage(nil, &Message{Id: 1})
+	r.OnMessage(nil, &Message{Id: 2})
+	if len(got) != 2 || got[0] != 1 || got[1] != 20 {
+		t.Fatal(got)
+	}
+}
+
+func TestRouterUnknown(t *testing.T) {
+	r := NewRouter()
+	var errs []error
+	r.HandleError(func(err error) {
+		errs = append(errs, err)
+	})
+	r.OnMessage(nil, &Message{Id: 3})
+	if len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	if e, ok := errs[0].(UnknownMessageError); !ok || e.Id != 3 {
+		t.Fatal(errs[0])
+	}
+	fallback := 0
+	r.Fallback(func(conn Conn, msg *Message) {
+		fallback++
+	})
+	r.OnMessage(nil, &Message{Id: 3})
+	if fallback != 1 || len(errs) != 1 {
+		t.Fail()
+	}
+}
+
+func TestRouterPanic(t *testing.T) {
+	r := NewRouter()
+	var errs []error
+	r.HandleError(func(err error) {
+		errs = append(errs, err)
+	})
+	r.Handle(1, func(conn Conn, msg *Message) {
+		panic("boom")
+	})
+	r.OnMessage(nil, &Message{Id: 1})
+	if len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	if e, ok := errs[0].(HandlerPanicError); !ok || e.Id != 1 || e.Value != "boom" {
+		t.Fatal(errs[0])
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	r := NewRouter()
+	var trace []string
+	mw := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(conn Conn, msg *Message) {
+				trace = append(trace, name)
+				next(conn, msg)
+			}
+		}
+	}
+	r.Use(mw("a"), mw("b"))
+	r.Handle(1, func(conn Conn, msg *Message) {
+		trace = append(trace, "handler")
+	}, mw("c"))
+	r.Handle(2, func(conn Conn, msg *Message) {
+		trace = append(trace, "denied")
+	}, FilterMiddleware(func(Conn, *Message) bool {
+		return false
+	}))
+	r.OnMessage(nil, &Message{Id: 1})
+	r.OnMessage(nil, &Message{Id: 2})
+	want := []string{"a", "b", "c", "handler", "a", "b"}
+	if len(trace) != len(want) {
+		t.Fatal(trace)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatal(trace)
+		}
+	}
+}
+
+func TestRouterChainCached(t *testing.T) {
+	r := NewRouter()
+	built := 0
+	mw := func(next HandlerFunc) HandlerFunc {
+		built++
+		return next
+	}
+	handled := 0
+	r.Handle(1, func(conn Conn, msg *Message) {
+		handled++
+	})
+	r.Use(mw)
+	r.Fallback(func(conn Conn, msg *Message) {
+		handled++
+	})
+	for i := 0; i < 10; i++ {
+		r.OnMessage(nil, &Message{Id: 1})
+		r.OnMessage(nil, &Message{Id: 2})
+	}
+	// 中间件链只在Use和Fallback时构建, 不随消息重复构建
+	if handled != 20 || built != 2 {
+		t.Fatal(handled, built)
+	}
+	r.Handle(3, func(conn Conn, msg *Message) {})
+	r.Remove(1)
+	r.OnMessage(nil, &Message{Id: 1})
+	if handled != 21 || built != 3 {
+		t.Fatal(handled, built)
+	}
+}
+
+func TestRouterErrorReporting(t *testing.T) {
+	logger := &recordLogger{}
+	r := NewRouter()
+	var mutex sync.Mutex
+	var errs []error
+	r.HandleError(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	})
+	r.Handle(1, func(conn Conn, msg *Message) {
+		panic("boom")
+	})
+	server, err := Listen(Tcp, 0, r, WithBindAddress("127.0.0.1"), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SendMessage(&Message{Id: 1})
+	client.SendMessage(&Message{Id: 2})
+	if !waitFor(time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(errs) == 2
+	}) {
+		t.Fatal("errors not reported")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	// 处理错误包装为ConnError, 连接不受影响
+	ce, ok := errs[0].(ConnError)
+	if !ok || ce.Phase != "handler" || ce.Conn == nil {
+		t.Fatal(errs[0])
+	}
+	if e, ok := ce.Err.(HandlerPanicError); !ok || e.Id != 1 || e.Value != "boom" {
+		t.Fatal(ce.Err)
+	}
+	if err := UnwrapConnError(errs[1]); err != (UnknownMessageError{2}) {
+		t.Fatal(errs[1])
+	}
+	if e, ok := logger.find("net error"); !ok || e.level != LevelError || e.fields["phase"] != "handler" {
+		t.Fatal("handler error not logged", e)
+	}
+	if server.Count() != 1 {
+		t.Fatal("connection closed by handler error")
+	}
+}
+
+// 嵌入Router并重写OnMessage的回调
+type overrideRouter struct {
+	*Router
+	overridden int32
+}
+
+func (r *overrideRouter) OnMessage(conn Conn, msg *Message) {
+	atomic.AddInt32(&r.overridden, 1)
+	r.Router.OnMessage(conn, msg)
+}
+
+func TestRouterEmbeddedOverride(t *testing.T) {
+	var handled int32
+	r := &overrideRouter{Router: NewRouter()}
+	r.Handle(1, func(conn Conn, msg *Message) {
+		atomic.AddInt32(&handled, 1)
+	})
+	server, err := Listen(Tcp, 0, r, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		client.SendMessage(&Message{Id: 1})
+	}
+	if !waitFor(time.Second, func() bool {
+		return atomic.LoadInt32(&handled) == 3
+	}) {
+		t.Fatal("not handled", atomic.LoadInt32(&handled))
+	}
+	if n := atomic.LoadInt32(&r.overridden); n != 3 {
+		t.Fatal("OnMessage override skipped", n)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRouter()
+	r.Use(LoggingMiddleware(NewStdLogger(log.New(&buf, "", 0), LevelDebug)))
+	r.Handle(1, func(conn Conn, msg *Message) {})
+	r.OnMessage(nil, &Message{Id: 1})
+	if !strings.HasPrefix(buf.String(), "DEBUG message handled id=1 remote=- elapsed=") {
+		t.Fatal(buf.String())
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type serverState int
+
+const (
+	serverIdle serverState = iota
+	serverRunning
+	serverClosing
+	serverClosed
+)
+
+// 服务器公共部分, 负责生命周期和连接管理
+// 具体协议只需实现bind/unbind
+type baseServer struct {
+	// 被拒绝的连接数, 需64位对齐, 放在首位
+	rejected    uint64
+	impl        Server
+	port        int
+	addr        net.Addr
+	options     *options
+	callback    Callback
+	clients     *sync.Map
+	connections int32
+	groups      connGroups
+	// 按IP的连接限制, 由mu保护
+	limiter connLimiter
+
+	mu      sync.Mutex
+	state   serverState
+	done    chan struct{}
+	quit    chan struct{}
+	closing int32
+	// 接收连接和心跳检查的后台协程
+	acceptWg sync.WaitGroup
+	// 已占用名额的连接, 包括握手中的WebSocket连接
+	connWg sync.WaitGroup
+}
+
+func (s *baseServer) setup(impl Server, port int, callback Callback, o *options) {
+	s.impl = impl
+	s.port = port
+	s.callback = callback
+	s.options = o
+	s.clients = &sync.Map{}
+	s.done = make(chan struct{})
+	s.quit = make(chan struct{})
+}
+
+// 绑定端口并在后台接收连接, 绑定完成后立即返回
+func (s *baseServer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state {
+	case serverRunning:
+		return ServerStateError{"server already started"}
+	case serverClosing, serverClosed:
+		return ServerStateError{"server closed"}
+	}
+	addr, err := s.impl.bind(s.listenAddress())
+	if err != nil {
+		return err
+	}
+	s.addr = addr
+	s.state = serverRunning
+	if s.options.heartbeatInterval > 0 || s.options.idleTimeout > 0 {
+		s.goAccept(s.checkHeartbeat)
+	}
+	return nil
+}
+
+// 启动服务器(如尚未启动)并阻塞, 直到ctx结束后优雅关闭, 或服务器被关闭
+func (s *baseServer) Serve(ctx context.Context) error {
+	if err := s.Start(); err != nil {
+		s.mu.Lock()
+		running := s.state == serverRunning
+		s.mu.Unlock()
+		if !running {
+			return err
+		}
+	}
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.options.shutdownTimeout)
+		defer cancel()
+		return s.Shutdown(shutdownCtx)
+	case <-s.done:
+		return nil
+	}
+}
+
+// 优雅关闭服务器
+// 停止接收新连接, 等待正在执行的回调完成后关闭所有连接, 每个连接都会触发OnDisconnected
+// 所有协程退出后返回; ctx结束时强制关闭剩余连接并返回ctx.Err()
+func (s *baseServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	switch s.state {
+	case serverIdle:
+		s.state = serverClosed
+		close(s.done)
+		s.mu.Unlock()
+		return nil
+	case serverClosing, serverClosed:
+		s.mu.Unlock()
+		<-s.done
+		return nil
+	}
+	s.state = serverClosing
+	atomic.StoreInt32(&s.closing, 1)
+	close(s.quit)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.state = serverClosed
+		close(s.done)
+		s.mu.Unlock()
+	}()
+
+	// 停止接收新连接
+	err := s.impl.unbind(ctx)
+	s.acceptWg.Wait()
+
+	// 中断阻塞中的读取, 正在执行的回调不受影响, 完成后读取协程退出
+	s.clients.Range(func(key, value interface{}) bool {
+		value.(Conn).stopRead()
+		return true
+	})
+	drained := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+	if err != nil && err != ctx.Err() {
+		return err
+	}
+	return nil
+}
+
+// 立即关闭服务器和所有连接, 不等待回调完成
+func (s *baseServer) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Shutdown(ctx); err != nil && err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// 实际监听的地址, 未启动时为空
+func (s *baseServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addr == nil {
+		return ""
+	}
+	return s.addr.String()
+}
+
+func (s *baseServer) isClosing() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+func (s *baseServer) closeConns() {
+	s.clients.Range(func(key, value interface{}) bool {
+		if err := value.(Conn).Close(); err != nil {
+			s.report(value.(Conn), "close", err)
+		}
+		return true
+	})
+}
+
+// 监听地址
+func (s *baseServer) listenAddress() string {
+	return net.JoinHostPort(s.options.bindAddress, strconv.Itoa(s.port))
+}
+
+// 在后台运行接收连接的循环
+func (s *baseServer) goAccept(loop func()) {
+	s.acceptWg.Add(1)
+	go func() {
+		defer s.acceptWg.Done()
+		loop()
+	}()
+}
+
+// 定期关闭心跳超时或空闲超时的连接
+func (s *baseServer) checkHeartbeat() {
+	heartbeat, idle := newWatchTickers(s.options)
+	defer stopTicker(heartbeat)
+	defer stopTicker(idle)
+	for {
+		select {
+		case <-tickerChan(heartbeat):
+			s.clients.Range(func(key, value interface{}) bool {
+				checkIdle(value.(Conn), s.options)
+				return true
+			})
+		case <-tickerChan(idle):
+			s.clients.Range(func(key, value interface{}) bool {
+				checkIdleTimeout(value.(Conn), s.options)
+				return true
+			})
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// 接收连接出错时的处理, 返回是否继续接收
+func (s *baseServer) acceptError(err error) bool {
+	if s.isClosing() {
+		return false
+	}
+	s.report(nil, "accept", err)
+	if ne, ok := err.(net.Error); ok && ne.Temporary() {
+		time.Sleep(10 * time.Millisecond)
+		return true
+	}
+	return false
+}
+
+// 为来自addr的连接占用一个名额
+// 服务器未运行时返回ServerStateError, 超出连接限制时返回RejectedError
+func (s *baseServer) acquireConn(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != serverRunning {
+		return ServerStateError{"server closing"}
+	}
+	if err := s.limiter.acquire(s.options, int(atomic.LoadInt32(&s.connections)), addr); err != nil {
+		return err
+	}
+	atomic.AddInt32(&s.connections, 1)
+	s.connWg.Add(1)
+	return nil
+}
+
+// 释放连接名额
+func (s *baseServer) releaseConn(addr string) {
+	s.mu.Lock()
+	s.limiter.release(addr)
+	s.mu.Unlock()
+	atomic.AddInt32(&s.connections, -1)
+	s.connWg.Done()
+}
+
+// 报告acquireConn失败的原因, 关闭过程中的拒绝不报告
+func (s *baseServer) reject(err error) {
+	rejected, ok := err.(RejectedError)
+	if !ok {
+		return
+	}
+	atomic.AddUint64(&s.rejected, 1)
+	s.options.metrics.ConnRejected(s.options.protocol)
+	if rc, ok := s.callback.(RejectCallback); ok {
+		s.options.logger.Log(LevelWarn, "connection rejected", "protocol", s.options.protocol, "remote", rejected.Addr, "reason", rejected.Reason)
+		rc.OnRejected(rejected)
+		return
+	}
+	s.report(nil, "reject", rejected)
+}
+
+// 因连接限制被拒绝的连接总数
+func (s *baseServer) Rejected() uint64 {
+	return atomic.LoadUint64(&s.rejected)
+}
+
+// 管理已接收的连接, 在后台完成握手并读取消息直到连接断开
+// 调用前需通过acquireConn占用名额, 连接断开后自动释放
+func (s *baseServer) serveConn(conn Conn, addr string) {
+	conn.touch()
+	conn.setState(ConnStateConnecting)
+	s.clients.Store(conn.Identity(), conn)
+	if s.isClosing() {
+		// 关闭过程中刚接收的连接, 同样需要中断读取
+		conn.stopRead()
+	}
+	s.options.metrics.ConnOpened(conn.NetProtocol())
+	go func() {
+		defer s.releaseConn(addr)
+		defer s.options.metrics.ConnClosed(conn.NetProtocol())
+		if err := conn.handshake(s.options.handshakeTimeout); err != nil {
+			conn.setState(ConnStateClosed)
+			s.clients.Delete(conn.Identity())
+			_ = conn.Close()
+			conn.clearAttributes()
+			if !s.isClosing() {
+				s.report(conn, "handshake", err)
+			}
+			return
+		}
+		if s.isClosing() {
+			// 握手结束时会清除截止时间, 需重新中断读取
+			conn.stopRead()
+		}
+		conn.setState(ConnStateConnected)
+		s.options.logger.Log(LevelInfo, "connection opened", connFields(s.options, conn, "")...)
+		if s.callback != nil {
+			s.callback.OnConnected(conn)
+		}
+		err := readMessages(conn, s.options, s.callback)
+		conn.setState(ConnStateClosed)
+		if cerr := conn.Close(); cerr != nil {
+			s.report(conn, "close", cerr)
+		}
+		// 关闭服务器和主动踢出导致的读取中断不作为错误报告
+		if _, kicked := err.(KickedError); !kicked && !s.isClosing() {
+			s.report(conn, "read", err)
+		}
+		s.options.logger.Log(LevelInfo, "connection closed", append(connFields(s.options, conn, ""), "reason", conn.CloseReason())...)
+		if s.callback != nil {
+			s.callback.OnDisconnected(conn)
+		}
+		conn.clearAttributes()
+		s.groups.remove(conn.Identity())
+		s.clients.Delete(conn.Identity())
+	}()
+}
+
+func (s *baseServer) GetConnection(identity uint32) (Conn, bool) {
+	if s.clients == nil {
+		return nil, false
+	}
+	if conn, ok := s.clients.Load(identity); ok {
+		return conn.(Conn), true
+	}
+	return nil, false
+}
+
+// 遍历所有已连接的连接, f返回false时停止
+func (s *baseServer) Range(f func(Conn) bool) {
+	if s.clients == nil {
+		return
+	}
+	s.clients.Range(func(key, value interface{}) bool {
+		if conn := value.(Conn); conn.State() == ConnStateConnected {
+			return f(conn)
+		}
+		return true
+	})
+}
+
+// 已连接的连接数
+func (s *baseServer) Count() int {
+	n := 0
+	s.Range(func(Conn) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// 所有已连接的连接
+func (s *baseServer) Conns() []Conn {
+	var conns []Conn
+	s.Range(func(conn Conn) bool {
+		conns = append(conns, conn)
+		return true
+	})
+	return conns
+}
+
+// 踢出连接, 对端收到的关闭原因为reason
+// WebSocket以关闭帧通知, 关闭码为ClosePolicyViolation; TCP/KCP以保留消息通知, 对端关闭原因为KickedError
+func (s *baseServer) Kick(id uint32, reason string) error {
+	conn, ok := s.GetConnection(id)
+	if !ok {
+		return ConnectionError{"Kick failed, connection " + strconv.FormatUint(uint64(id), 10) + " not found"}
+	}
+	return kickConn(conn, s.options.codec, reason)
+}
+
+// 发送消息到所有已连接的连接
+func (s *baseServer) Broadcast(msg *Message) error {
+	return fanOut(s.options.codec, s.Conns(), msg)
+}
+
+// 发送消息到指定id的连接, 不存在的连接记录为发送失败
+func (s *baseServer) SendTo(ids []uint32, msg *Message) error {
+	conns := make([]Conn, 0, len(ids))
+	missing := make(map[uint32]error)
+	for _, id := range ids {
+		if conn, ok := s.GetConnection(id); ok && conn.State() == ConnStateConnected {
+			conns = append(conns, conn)
+		} else {
+			missing[id] = ConnectionError{"connection " + strconv.FormatUint(uint64(id), 10) + " not found"}
+		}
+	}
+	err := fanOut(s.options.codec, conns, msg)
+	if len(missing) == 0 {
+		return err
+	}
+	if sendErr, ok := err.(SendError); ok {
+		for id, e := range sendErr.Errors {
+			missing[id] = e
+		}
+	} else if err != nil {
+		return err
+	}
+	return SendError{Errors: missing}
+}
+
+// 连接加入分组, 连接断开时自动退出所有分组
+func (s *baseServer) Join(group string, id uint32) error {
+	conn, ok := s.GetConnection(id)
+	if !ok || conn.State() == ConnStateClosed {
+		return ConnectionError{"Join failed, connection " + strconv.FormatUint(uint64(id), 10) + " not found"}
+	}
+	s.groups.join(group, conn)
+	// 加入分组时连接可能刚好断开, 断开后不应留在分组中
+	if conn.State() == ConnStateClosed {
+		s.groups.remove(id)
+	}
+	return nil
+}
+
+func (s *baseServer) Leave(group string, id uint32) {
+	s.groups.leave(group, id)
+}
+
+// 发送消息到分组内的所有连接
+func (s *baseServer) SendGroup(group string, msg *Message) error {
+	return fanOut(s.options.codec, s.groups.members(group), msg)
+}
+
+// 报告错误, conn为产生错误的连接, 没有时为nil
+func (s *baseServer) report(conn Conn, phase string, err error) {
+	reportError(s.options, s.callback, conn, phase, err)
+}
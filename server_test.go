@@ -0,0 +1,457 @@
+package net
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testCallback struct {
+	sync.Mutex
+	messages     []*Message
+	connected    []Conn
+	disconnected []Conn
+	errors       []error
+	handler      func(Conn, *Message)
+}
+
+func (c *testCallback) OnMessage(conn Conn, msg *Message) {
+	if c.handler != nil {
+		c.handler(conn, msg)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.messages = append(c.messages, msg)
+}
+
+func (c *testCallback) OnConnected(conn Conn) {
+	c.Lock()
+	defer c.Unlock()
+	c.connected = append(c.connected, conn)
+}
+
+func (c *testCallback) OnDisconnected(conn Conn) {
+	c.Lock()
+	defer c.Unlock()
+	c.disconnected = append(c.disconnected, conn)
+}
+
+func (c *testCallback) OnError(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.errors = append(c.errors, err)
+}
+
+func (c *testCallback) count() (messages, connected, disconnected int) {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.messages), len(c.connected), len(c.disconnected)
+}
+
+// 等待条件满足, 超时返回false
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestServerShutdownDrainsHandlers(t *testing.T) {
+	handled := make(chan struct{})
+	cb := &testCallback{}
+	cb.handler = func(conn Conn, msg *Message) {
+		time.Sleep(100 * time.Millisecond)
+		close(handled)
+	}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, _ := NewLengthCodec().Encode(&Message{Id: 1, Payload: []byte("hello")})
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	default:
+		t.Fatal("shutdown returned before handler finished")
+	}
+	messages, _, disconnected := cb.count()
+	if messages != 1 || disconnected != 1 {
+		t.Fatal(messages, disconnected)
+	}
+	if len(cb.errors) != 0 {
+		t.Fatal(cb.errors)
+	}
+	if _, err := net.Dial("tcp", server.Addr()); err == nil {
+		t.Fatal("server still accepting")
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	cb := &testCallback{}
+	cb.handler = func(conn Conn, msg *Message) {
+		<-block
+	}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, _ := NewLengthCodec().Encode(&Message{Id: 1, Payload: nil})
+	conn.Write(data)
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	for _, protocol := range []Protocol{Tcp, WebSocket, Kcp} {
+		server, err := NewServer(protocol, 0, &testCallback{}, WithBindAddress("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error)
+		go func() {
+			served <- server.Serve(ctx)
+		}()
+		if !waitFor(time.Second, func() bool {
+			return server.Addr() != ""
+		}) {
+			t.Fatal(protocol, "not started")
+		}
+		cancel()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Fatal(protocol, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal(protocol, "serve not returned")
+		}
+		if err := server.Start(); err == nil {
+			t.Fatal(protocol, "restart closed server")
+		}
+	}
+}
+
+func TestWsServerMultiple(t *testing.T) {
+	var servers []Server
+	for i := 0; i < 2; i++ {
+		cb := &testCallback{}
+		server, err := Listen(WebSocket, 0, cb, WithBindAddress("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, server)
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := cb.count()
+			return connected == 1
+		}) {
+			t.Fatal("not connected")
+		}
+		if err := server.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, _, disconnected := cb.count()
+			return disconnected == 1
+		}) {
+			t.Fatal("not disconnected")
+		}
+	}
+}
+
+func TestServerConnsAndKick(t *testing.T) {
+	for _, protocol := range []Protocol{Tcp, WebSocket, Kcp} {
+		serverCb := &testCallback{}
+		server, err := Listen(protocol, 0, serverCb, WithBindAddress("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := server.Addr()
+		if protocol == WebSocket {
+			addr = "ws://" + addr
+		}
+		cb := &testCallback{}
+		client := newTestClient(t, protocol, cb)
+		if err := client.connect(context.Background(), addr); err != nil {
+			t.Fatal(protocol, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := cb.count()
+			return connected == 1
+		}) {
+			t.Fatal(protocol, "client not connected")
+		}
+		// KCP连接在收到第一个包后才被服务器接收
+		client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := serverCb.count()
+			return connected == 1
+		}) {
+			t.Fatal(protocol, "not connected")
+		}
+		if server.Count() != 1 || len(server.Conns()) != 1 {
+			t.Fatal(protocol, server.Count(), server.Conns())
+		}
+		var id uint32
+		server.Range(func(conn Conn) bool {
+			id = conn.Identity()
+			return false
+		})
+		if err := server.Kick(id, "maintenance"); err != nil {
+			t.Fatal(protocol, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, _, disconnected := cb.count()
+			return disconnected == 1
+		}) {
+			t.Fatal(protocol, "client not disconnected")
+		}
+		cb.Lock()
+		reason := cb.disconnected[0].CloseReason()
+		cb.Unlock()
+		switch protocol {
+		case WebSocket:
+			if ce, ok := reason.(CloseError); !ok || ce.Code != ClosePolicyViolation || ce.Text != "maintenance" {
+				t.Fatal(protocol, reason)
+			}
+		default:
+			if reason != (KickedError{"maintenance"}) {
+				t.Fatal(protocol, reason)
+			}
+		}
+		if !waitFor(time.Second, func() bool {
+			return server.Count() == 0
+		}) {
+			t.Fatal(protocol, "kicked connection still counted")
+		}
+		serverCb.Lock()
+		if len(serverCb.errors) != 0 {
+			t.Fatal(protocol, serverCb.errors)
+		}
+		serverCb.Unlock()
+		if err := server.Kick(id, "again"); err == nil {
+			t.Fatal(protocol, "kicked unknown connection")
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestServerRejectsClientKick(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// 客户端伪造的踢出消息不会关闭服务器上的连接
+	client.SendMessage(&Message{Id: MessageIdKick, Payload: []byte("spoofed")})
+	client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := serverCb.count()
+		return messages == 1
+	}) {
+		t.Fatal("connection closed by client kick")
+	}
+	if err := firstError(t, serverCb, time.Second); err != (InvalidMessageError{"unexpected kick message from client"}) {
+		t.Fatal(err)
+	}
+	if _, _, disconnected := serverCb.count(); disconnected != 0 || server.Count() != 1 {
+		t.Fatal("disconnected", disconnected, server.Count())
+	}
+}
+
+func TestTextMessageRequiresWebSocket(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Connect(Tcp, server.Addr(), &testCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	text := &Message{Payload: []byte("hello"), Type: TextMessage}
+	want := ConnectionError{"Send failed: text frames require a WebSocket connection"}
+	if err := client.SendMessage(text); err != want {
+		t.Fatal("client", err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	serverCb.Lock()
+	conn := serverCb.connected[0]
+	serverCb.Unlock()
+	// 服务器端的连接与客户端行为一致
+	if err := conn.SendMessage(text); err != want {
+		t.Fatal("server", err)
+	}
+	if err := <-conn.SendAsync(text); err != want {
+		t.Fatal("server async", err)
+	}
+}
+
+type attributeCallback struct {
+	testCallback
+	seen         chan interface{}
+	disconnected chan interface{}
+}
+
+func (c *attributeCallback) OnConnected(conn Conn) {
+	conn.Set("user", "alice")
+	conn.Set("temp", 1)
+	conn.Delete("temp")
+	c.testCallback.OnConnected(conn)
+}
+
+func (c *attributeCallback) OnMessage(conn Conn, msg *Message) {
+	user, _ := conn.Get("user")
+	c.seen <- user
+}
+
+func (c *attributeCallback) OnDisconnected(conn Conn) {
+	user, _ := conn.Get("user")
+	c.disconnected <- user
+	c.testCallback.OnDisconnected(conn)
+}
+
+func TestConnAttributes(t *testing.T) {
+	cb := &attributeCallback{seen: make(chan interface{}, 1), disconnected: make(chan interface{}, 1)}
+	server, err := Listen(Tcp, 0, cb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := newTestClient(t, Tcp, &testCallback{})
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	cb.Lock()
+	conn := cb.connected[0]
+	cb.Unlock()
+	if _, ok := conn.Get("temp"); ok {
+		t.Fatal("deleted attribute still present")
+	}
+	client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+	if user := <-cb.seen; user != "alice" {
+		t.Fatal(user)
+	}
+	client.Close()
+	if user := <-cb.disconnected; user != "alice" {
+		t.Fatal("attribute cleared before OnDisconnected", user)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, ok := conn.Get("user")
+		return !ok
+	}) {
+		t.Fatal("attribute not cleared after disconnect")
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	serverCb := echoCallback()
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithMaxMessageSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 只发送声明长度为1GB的包头
+	conn.Write([]byte{0x40, 0, 0, 0})
+	if err := firstError(t, serverCb, time.Second); err != (InvalidMessageLengthError{Length: 4 + 1<<30, Limit: 64}) {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := serverCb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("not disconnected")
+	}
+}
+
+func TestDefaultMaxMessageSize(t *testing.T) {
+	serverCb := echoCallback()
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 未设置限制时默认拒绝超过4MB的消息
+	conn.Write([]byte{0x40, 0, 0, 0})
+	if err := firstError(t, serverCb, time.Second); err != (InvalidMessageLengthError{Length: 4 + 1<<30, Limit: defaultMaxMessageSize}) {
+		t.Fatal(err)
+	}
+	// 显式设置为0时不限制
+	o, err := newOptions(Tcp, true, []Option{WithMaxMessageSize(0)})
+	if err != nil || o.maxMessageSize != 0 {
+		t.Fatal(o.maxMessageSize, err)
+	}
+}
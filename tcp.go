@@ -15,197 +15,119 @@
 package net
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
-	"strconv"
-	"sync"
+	"time"
 )
 
 type tcpServer struct {
-	listener *net.TCPListener
-	callback Callback
-	clients  *sync.Map
+	baseServer
+	listener net.Listener
 }
 
-func (s *tcpServer) listen(port int, callback Callback) error {
-	addr, err := net.ResolveTCPAddr("tcp", ":"+strconv.Itoa(port))
+func (s *tcpServer) bind(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	listener, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		return err
+	if s.options.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.options.tlsConfig)
 	}
-	defer func() {
-		err := listener.Close()
-		if err != nil && callback != nil {
-			callback.OnError(err)
-		}
-	}()
 	s.listener = listener
-	s.clients = &sync.Map{}
-	s.callback = callback
-	for {
-		conn, err := listener.AcceptTCP()
-		if err != nil {
-			if s.callback != nil {
-				s.callback.OnError(err)
-			}
-			continue
-		}
-		c := Conn(&tcpConn{conn: conn})
-		c.setState(ConnStateConnected)
-		s.clients.Store(c.Identity(), c)
-		if s.callback != nil {
-			s.callback.OnConnected(c)
-		}
-		go s.handleConnection(c, callback)
+	s.goAccept(func() {
+		s.accept(listener)
+	})
+	return listener.Addr(), nil
+}
+
+func (s *tcpServer) unbind(ctx context.Context) error {
+	if s.listener != nil {
+		return s.listener.Close()
 	}
+	return nil
 }
 
-// 处理消息流
-func (s *tcpServer) handleConnection(conn Conn, callback Callback) {
-	buf := make([]byte, 4096) // 4KB
-	byteBuffer := make([]byte, 0)
-	defer func() {
-		err := conn.Close()
-		if err != nil && callback != nil {
-			callback.OnError(err)
-		}
-	}()
+func (s *tcpServer) accept(listener net.Listener) {
 	for {
-		l, err := conn.read(&buf)
+		conn, err := listener.Accept()
 		if err != nil {
-			conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(conn)
+			if s.acceptError(err) {
+				continue
 			}
-			s.clients.Delete(conn.Identity())
-			break
-		}
-		byteBuffer = append(byteBuffer, buf[:l]...)
-		if callback != nil {
-			callback.OnMessage(conn, byteBuffer)
-		}
-	}
-}
-
-func (s *tcpServer) GetConnection(identity uint32) (Conn, bool) {
-	if s.clients == nil {
-		return nil, false
-	} else {
-		if identity, ok := s.clients.Load(identifier); ok {
-			return identity.(Conn), ok
-		} else {
-			return nil, false
+			return
 		}
-	}
-}
-
-func (s *tcpServer) Close() error {
-	if s.clients != nil {
-		s.clients.Range(func(key, value interface{}) bool {
-			conn := value.(Conn)
-			if conn != nil {
-				err := conn.Close()
-				if err != nil && s.callback != nil {
-					s.callback.OnError(err)
-				}
+		addr := conn.RemoteAddr().String()
+		if err := s.acquireConn(addr); err != nil {
+			if cerr := conn.Close(); cerr != nil {
+				s.report(nil, "reject", cerr)
 			}
-			return true
-		})
-	}
-	if s.listener != nil {
-		err := s.listener.Close()
-		if err != nil {
-			return err
+			s.reject(err)
+			continue
 		}
-		s.listener = nil
+		s.serveConn(newTcpConn(conn, s.options), addr)
 	}
-	return nil
 }
 
 type tcpClient struct {
-	sync.Mutex
-	serverAddr string
-	callback   Callback
-	conn       Conn
-}
-
-func (c *tcpClient) Reconnect() error {
-	return c.connect(c.serverAddr, c.callback)
+	baseClient
 }
 
-func (c *tcpClient) connect(serverAddr string, callback Callback) error {
-	c.serverAddr = serverAddr
-	c.callback = callback
-	addr, err := net.ResolveTCPAddr("tcp", serverAddr)
-	if err != nil {
-		return err
+func (c *tcpClient) dial(ctx context.Context, serverAddr string) (Conn, error) {
+	if c.options.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.dialTimeout)
+		defer cancel()
 	}
-	conn, err := net.DialTCP("tcp", nil, addr)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
+	if c.options.tlsConfig != nil {
+		if conn, err = tlsHandshake(ctx, conn, serverAddr, c.options.tlsConfig, c.options.handshakeTimeout); err != nil {
+			return nil, err
 		}
-	}()
-	c.conn = Conn(&tcpConn{conn: conn})
-	c.conn.setState(ConnStateConnected)
-	if callback != nil {
-		callback.OnConnected(c.conn)
 	}
-	c.handleConnection(c.conn, callback)
-	return nil
+	return newTcpConn(conn, c.options), nil
 }
 
-// 处理消息流
-func (c *tcpClient) handleConnection(conn Conn, callback Callback) {
-	buf := make([]byte, 4096) // 4KB
-	byteBuffer := make([]byte, 0)
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
-		}
-	}()
-	for {
-		l, err := conn.read(&buf)
+// 在ctx内完成TLS客户端握手, 失败或ctx结束时关闭连接
+func tlsHandshake(ctx context.Context, conn net.Conn, serverAddr string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(serverAddr)
 		if err != nil {
-			c.conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(c.conn)
-			}
-			c.conn = nil
-			break
-		}
-		byteBuffer = append(byteBuffer, buf[:l]...)
-		if callback != nil {
-			callback.OnMessage(conn, byteBuffer)
+			host = serverAddr
 		}
+		config = config.Clone()
+		config.ServerName = host
 	}
-}
-
-func (c *tcpClient) Send(msg []byte) error {
-	if c.conn != nil && c.conn.State() == ConnStateConnected {
-		return c.conn.Send(msg)
-	} else {
-		return ConnectionError{"Send failed: connection was not built"}
+	// 握手超时与ctx分开计时, 以区分握手超时和调用方取消
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
 	}
-}
-
-func (c *tcpClient) Close() error {
-	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
+	tlsConn := tls.Client(conn, config)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tlsConn.Handshake()
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			conn.Close()
+			return nil, err
 		}
-		c.conn = nil
+		return tlsConn, nil
+	case <-expired:
+		conn.Close()
+		<-errc
+		return nil, TimeoutError{Op: "handshake", Timeout: timeout}
+	case <-ctx.Done():
+		conn.Close()
+		<-errc
+		return nil, ctx.Err()
 	}
-	return nil
 }
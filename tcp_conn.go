@@ -15,42 +15,77 @@
 package net
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"time"
 )
 
 type tcpConn struct {
 	baseConn
-	conn *net.TCPConn
+	conn net.Conn
+}
+
+func newTcpConn(conn net.Conn, o *options) *tcpConn {
+	c := &tcpConn{conn: conn}
+	c.setup(c, o)
+	return c
 }
 
 func (c *tcpConn) Send(msg []byte) error {
-	if c.conn != nil {
-		if msg == nil || len(msg) == 0 {
-			return EmptyMessageError{}
-		}
-		_, err := c.conn.Write(msg)
-		if err != nil {
-			return err
-		}
-		return nil
-	} else {
+	if c.conn == nil {
 		return ConnectionError{"Send failed, connection was not built"}
 	}
+	return c.send(BinaryMessage, msg)
+}
+
+func (c *tcpConn) SendMessage(msg *Message) error {
+	_, data, err := c.frame(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+func (c *tcpConn) writeFrame(typ MessageType, data []byte) error {
+	c.prepareWrite()
+	_, err := c.conn.Write(data)
+	return c.timeoutError("write", err)
+}
+
+func (c *tcpConn) ping() error {
+	return c.SendMessage(&Message{Id: MessageIdPing})
 }
 
 func (c *tcpConn) read(buf *[]byte) (int, error) {
+	if c.conn != nil && !c.isClosed() {
+		c.prepareRead()
+		n, err := c.conn.Read(*buf)
+		// 关闭后读取到的数据不再处理
+		if !c.isClosed() {
+			return n, c.timeoutError("read", err)
+		}
+	}
+	return -1, ConnectionError{"Read failed, connection was closed"}
+}
+
+func (c *tcpConn) setReadDeadline(t time.Time) error {
 	if c.conn != nil {
-		return c.conn.Read(*buf)
+		return c.conn.SetReadDeadline(t)
 	}
-	return -1, nil
+	return nil
 }
 
-func (c *tcpConn) Close() error {
+func (c *tcpConn) setWriteDeadline(t time.Time) error {
 	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
-		}
-		c.conn = nil
+		return c.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *tcpConn) Close() error {
+	if c.conn != nil && c.markClosed() {
+		return c.closeWriter(c.conn.Close)
 	}
 	return nil
 }
@@ -69,6 +104,30 @@ func (c *tcpConn) LocalAddr() string {
 	return "0:0:0:0"
 }
 
+func (c *tcpConn) PeerCertificates() []*x509.Certificate {
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		return tc.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
+// 服务器端TLS握手, 在OnConnected之前完成以便回调中获取对端证书
+func (c *tcpConn) handshake(timeout time.Duration) error {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if timeout > 0 {
+		if err := tc.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	return tc.SetDeadline(time.Time{})
+}
+
 func (c *tcpConn) NetProtocol() Protocol {
 	return Tcp
 }
@@ -0,0 +1,160 @@
+package net
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// 等待服务器报告第一个错误
+func firstError(t *testing.T, cb *testCallback, timeout time.Duration) error {
+	if !waitFor(timeout, func() bool {
+		cb.Lock()
+		defer cb.Unlock()
+		return len(cb.errors) > 0
+	}) {
+		t.Fatal("no error reported")
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	return UnwrapConnError(cb.errors[0])
+}
+
+func TestReadTimeout(t *testing.T) {
+	for _, protocol := range []Protocol{Tcp, WebSocket, Kcp} {
+		serverCb := &testCallback{}
+		server, err := Listen(protocol, 0, serverCb, WithBindAddress("127.0.0.1"), WithReadTimeout(100*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := server.Addr()
+		if protocol == WebSocket {
+			addr = "ws://" + addr
+		}
+		client := newTestClient(t, protocol, &testCallback{})
+		if err := client.connect(context.Background(), addr); err != nil {
+			t.Fatal(protocol, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			client.SendMessage(&Message{Id: 1, Payload: []byte("hello")})
+			_, connected, _ := serverCb.count()
+			return connected == 1
+		}) {
+			t.Fatal(protocol, "not connected")
+		}
+		if err := firstError(t, serverCb, time.Second); err != (TimeoutError{Op: "read", Timeout: 100 * time.Millisecond}) {
+			t.Fatal(protocol, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, _, disconnected := serverCb.count()
+			return disconnected == 1
+		}) {
+			t.Fatal(protocol, "not disconnected")
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithIdleTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := newTestClient(t, Tcp, &testCallback{})
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	// 持续收发时不会超时
+	for i := 0; i < 6; i++ {
+		client.SendMessage(&Message{Id: 1, Payload: []byte("active")})
+		time.Sleep(30 * time.Millisecond)
+	}
+	if _, _, disconnected := serverCb.count(); disconnected != 0 {
+		t.Fatal("active connection closed")
+	}
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := serverCb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("idle connection not closed")
+	}
+	serverCb.Lock()
+	defer serverCb.Unlock()
+	if reason := serverCb.disconnected[0].CloseReason(); reason != (TimeoutError{Op: "idle", Timeout: 100 * time.Millisecond}) {
+		t.Fatal(reason)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	sent := make(chan error, 1)
+	serverCb := &testCallback{}
+	serverCb.handler = func(conn Conn, msg *Message) {
+		// 对端不读取数据, 写满缓冲区后写入超时
+		payload := make([]byte, 1<<20)
+		for {
+			if err := conn.SendMessage(&Message{Id: 1, Payload: payload}); err != nil {
+				sent <- err
+				return
+			}
+		}
+	}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithWriteTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, _ := NewLengthCodec().Encode(&Message{Id: 1, Payload: []byte("hello")})
+	conn.Write(data)
+	select {
+	case err := <-sent:
+		if err != (TimeoutError{Op: "write", Timeout: 100 * time.Millisecond}) {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write not timed out")
+	}
+}
+
+func TestConnSetDeadline(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithReadTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := newTestClient(t, Tcp, &testCallback{})
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected")
+	}
+	serverCb.Lock()
+	conn := serverCb.connected[0]
+	serverCb.Unlock()
+	// 较早的截止时间优先于读超时
+	conn.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	if err := firstError(t, serverCb, time.Second); err != (TimeoutError{Op: "read"}) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,227 @@
+package net
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+var serialNumber int64
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serialNumber),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// 签发证书, hosts非空时为服务端证书
+func (ca *testCA) issue(t *testing.T, commonName string, hosts ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) > 0 {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTcpTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCb := &testCallback{}
+	serverCb.handler = func(conn Conn, msg *Message) {
+		conn.SendMessage(msg)
+	}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"),
+		WithTLSCertificate(ca.issue(t, "server", "127.0.0.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, Tcp, cb, WithTLSRootCAs(ca.pool))
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 1, Payload: []byte("secure")})
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("no echo")
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if string(cb.messages[0].Payload) != "secure" {
+		t.Fatal(cb.messages[0])
+	}
+	certs := cb.connected[0].PeerCertificates()
+	if len(certs) == 0 || certs[0].Subject.CommonName != "server" {
+		t.Fatal(certs)
+	}
+}
+
+func TestTcpMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"),
+		WithTLSCertificate(ca.issue(t, "server", "127.0.0.1")),
+		WithTLSClientAuth(ca.pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// 未出示客户端证书
+	client := newTestClient(t, Tcp, &testCallback{}, WithTLSRootCAs(ca.pool))
+	// TLS 1.3下服务器在握手完成后才校验客户端证书, 客户端连接可能成功也可能失败
+	client.connect(context.Background(), server.Addr())
+	if !waitFor(time.Second, func() bool {
+		serverCb.Lock()
+		defer serverCb.Unlock()
+		return len(serverCb.errors) == 1
+	}) {
+		t.Fatal("handshake not rejected")
+	}
+	client.Close()
+	if _, connected, _ := serverCb.count(); connected != 0 {
+		t.Fatal("unauthenticated client connected")
+	}
+
+	client = newTestClient(t, Tcp, &testCallback{}, WithTLSRootCAs(ca.pool),
+		WithTLSCertificate(ca.issue(t, "player-42")))
+	if err := client.connect(context.Background(), server.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", serverCb.errors)
+	}
+	serverCb.Lock()
+	defer serverCb.Unlock()
+	certs := serverCb.connected[0].PeerCertificates()
+	if len(certs) == 0 || certs[0].Subject.CommonName != "player-42" {
+		t.Fatal(certs)
+	}
+}
+
+func TestTcpTLSServerName(t *testing.T) {
+	ca := newTestCA(t)
+	server, err := Listen(Tcp, 0, &testCallback{}, WithBindAddress("127.0.0.1"),
+		WithTLSCertificate(ca.issue(t, "game", "game.example.com")),
+		WithTLSCertificate(ca.issue(t, "admin", "admin.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	for _, name := range []string{"game", "admin"} {
+		cb := &testCallback{}
+		client := newTestClient(t, Tcp, cb, WithTLSRootCAs(ca.pool), WithTLSServerName(name+".example.com"))
+		if err := client.connect(context.Background(), server.Addr()); err != nil {
+			t.Fatal(name, err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := cb.count()
+			return connected == 1
+		}) {
+			t.Fatal(name, "not connected", cb.errors)
+		}
+		cb.Lock()
+		if cn := cb.connected[0].PeerCertificates()[0].Subject.CommonName; cn != name {
+			t.Error(name, cn)
+		}
+		cb.Unlock()
+		client.Close()
+	}
+}
+
+func TestTcpTLSHandshakeTimeout(t *testing.T) {
+	// 对端接受连接后不响应握手
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	start := time.Now()
+	_, err = Connect(Tcp, listener.Addr().String(), nil,
+		WithTLSConfig(&tls.Config{InsecureSkipVerify: true}), WithHandshakeTimeout(100*time.Millisecond))
+	if err != (TimeoutError{Op: "handshake", Timeout: 100 * time.Millisecond}) {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("handshake timeout ignored", elapsed)
+	}
+}
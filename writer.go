@@ -0,0 +1,275 @@
+// Copyright 2017 bingo Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// 关闭连接时等待写队列发送完成的最长时间, 超时后丢弃剩余数据
+const writeFlushTimeout = time.Second
+
+// 待发送的一帧数据
+type outFrame struct {
+	typ  MessageType
+	data []byte
+	done chan error
+}
+
+func (f *outFrame) complete(err error) {
+	if f.done != nil {
+		f.done <- err
+	}
+}
+
+// 连接的发送部分, 保证同一连接的写操作串行执行
+// 未开启写队列时在调用者协程中加锁写入, 开启后由写协程按顺序发送
+type connWriter struct {
+	impl       Conn
+	metrics    Metrics
+	writeMutex sync.Mutex
+	queue      chan *outFrame
+	policy     OverflowPolicy
+	// 入队与关闭之间的同步, 保证关闭后不会有数据滞留在队列中
+	queueMutex sync.RWMutex
+	stop       chan struct{}
+	drain      chan struct{}
+	stopped    chan struct{}
+}
+
+// 初始化发送部分
+func (c *baseConn) initWriter(impl Conn, o *options) {
+	c.writer.impl = impl
+	c.writer.metrics = o.metrics
+	if o.writeQueueSize > 0 {
+		c.writer.queue = make(chan *outFrame, o.writeQueueSize)
+		c.writer.policy = o.writeQueuePolicy
+		c.writer.stop = make(chan struct{})
+		c.writer.drain = make(chan struct{})
+		c.writer.stopped = make(chan struct{})
+		go c.writer.loop()
+	}
+}
+
+// 发送一帧数据
+// 开启写队列时数据入队后即返回, 发送失败会关闭连接, 失败原因记录为关闭原因
+func (c *baseConn) send(typ MessageType, data []byte) error {
+	if len(data) == 0 {
+		return EmptyMessageError{}
+	}
+	if c.isClosed() || c.impl == nil {
+		return ConnectionError{"Send failed, connection was not built"}
+	}
+	return c.writer.send(&outFrame{typ: typ, data: data})
+}
+
+// 异步发送消息, 返回的通道在消息写入连接或发送失败后收到结果
+func (c *baseConn) SendAsync(msg *Message) <-chan error {
+	done := make(chan error, 1)
+	typ, data, err := c.frame(msg)
+	if err == nil && c.isClosed() {
+		err = ConnectionError{"Send failed, connection was closed"}
+	}
+	if err == nil && c.impl == nil {
+		err = ConnectionError{"Send failed, connection was not built"}
+	}
+	if err != nil {
+		done <- err
+		return done
+	}
+	if err := c.writer.send(&outFrame{typ: typ, data: data, done: done}); err != nil {
+		// 入队失败时帧未被处理, 直接返回错误; 丢弃最早数据时由被丢弃的帧自行报告
+		select {
+		case done <- err:
+		default:
+		}
+	}
+	return done
+}
+
+// 发送通知类消息(如踢出原因), 写队列已满时直接放弃, 避免阻塞关闭流程
+func (c *baseConn) notify(msg *Message) error {
+	typ, data, err := c.frame(msg)
+	if err != nil {
+		return err
+	}
+	if c.isClosed() || c.impl == nil {
+		return ConnectionError{"Send failed, connection was closed"}
+	}
+	w := &c.writer
+	if w.queue == nil {
+		return w.send(&outFrame{typ: typ, data: data})
+	}
+	w.queueMutex.RLock()
+	defer w.queueMutex.RUnlock()
+	select {
+	case <-w.stop:
+		return ConnectionError{"Send failed, connection was closed"}
+	case w.queue <- &outFrame{typ: typ, data: data}:
+		return nil
+	default:
+		return WriteQueueFullError{}
+	}
+}
+
+// 消息转换为待发送的帧, 文本消息不经过编解码器, 仅WebSocket连接支持
+func (c *baseConn) frame(msg *Message) (MessageType, []byte, error) {
+	if msg != nil && msg.Type == TextMessage {
+		if c.impl == nil || c.impl.NetProtocol() != WebSocket {
+			return TextMessage, nil, ConnectionError{"Send failed: text frames require a WebSocket connection"}
+		}
+		if len(msg.Payload) == 0 {
+			return TextMessage, nil, EmptyMessageError{}
+		}
+		return TextMessage, msg.Payload, nil
+	}
+	data, err := c.encode(msg)
+	if err == nil && len(data) == 0 {
+		err = EmptyMessageError{}
+	}
+	return BinaryMessage, data, err
+}
+
+// 停止写入并关闭底层连接, 连接立即标记为关闭并停止读取
+// 开启写队列时先在后台发送队列中剩余的数据, 最多等待writeFlushTimeout
+func (c *baseConn) closeWriter(closeRaw func() error) error {
+	c.setState(ConnStateClosed)
+	w := &c.writer
+	if w.queue == nil {
+		return closeRaw()
+	}
+	// 读取立即停止, 只有写入等待队列发送完成
+	c.stopRead()
+	close(w.stop)
+	w.queueMutex.Lock()
+	close(w.drain)
+	w.queueMutex.Unlock()
+	go func() {
+		timer := time.NewTimer(writeFlushTimeout)
+		defer timer.Stop()
+		select {
+		case <-w.stopped:
+		case <-timer.C:
+		}
+		_ = closeRaw()
+	}()
+	return nil
+}
+
+func (w *connWriter) send(f *outFrame) error {
+	if w.queue == nil {
+		w.writeMutex.Lock()
+		err := w.impl.writeFrame(f.typ, f.data)
+		w.writeMutex.Unlock()
+		w.written(f, err)
+		return err
+	}
+	err := w.enqueue(f)
+	if err != nil {
+		w.metrics.SendFailed(w.impl.NetProtocol())
+	} else {
+		w.metrics.QueueDepth(w.impl.NetProtocol(), len(w.queue))
+	}
+	if _, full := err.(WriteQueueFullError); full && w.policy == OverflowDisconnect {
+		_ = closeWithReason(w.impl, err)
+	}
+	return err
+}
+
+func (w *connWriter) enqueue(f *outFrame) error {
+	w.queueMutex.RLock()
+	defer w.queueMutex.RUnlock()
+	select {
+	case <-w.stop:
+		return ConnectionError{"Send failed, connection was closed"}
+	default:
+	}
+	switch w.policy {
+	case OverflowBlock:
+		select {
+		case w.queue <- f:
+			return nil
+		case <-w.stop:
+			return ConnectionError{"Send failed, connection was closed"}
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case w.queue <- f:
+				return nil
+			default:
+			}
+			select {
+			case old := <-w.queue:
+				w.metrics.SendFailed(w.impl.NetProtocol())
+				old.complete(WriteQueueFullError{})
+			default:
+			}
+		}
+	default:
+		select {
+		case w.queue <- f:
+			return nil
+		default:
+			return WriteQueueFullError{}
+		}
+	}
+}
+
+// 写协程, 连接关闭后发送完队列中剩余的数据再退出
+// 写入失败时关闭连接, 之后的数据均以失败结束
+func (w *connWriter) loop() {
+	defer close(w.stopped)
+	for {
+		select {
+		case f := <-w.queue:
+			w.write(f)
+		case <-w.drain:
+			for {
+				select {
+				case f := <-w.queue:
+					w.write(f)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *connWriter) write(f *outFrame) {
+	err := w.impl.writeFrame(f.typ, f.data)
+	w.written(f, err)
+	if err != nil {
+		select {
+		case <-w.stop:
+			// 连接已关闭, 写入失败是关闭导致的
+		default:
+			_ = closeWithReason(w.impl, err)
+		}
+	}
+}
+
+// 记录一帧的写入结果并通知发送者
+func (w *connWriter) written(f *outFrame, err error) {
+	if err == nil {
+		w.impl.touchWrite()
+		w.metrics.MessageSent(w.impl.NetProtocol(), len(f.data))
+	} else {
+		w.metrics.SendFailed(w.impl.NetProtocol())
+	}
+	f.complete(err)
+}
@@ -0,0 +1,255 @@
+package net
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 写入阻塞直到release的连接, 用于测试写队列
+type blockingConn struct {
+	baseConn
+	started chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	written []string
+	closed  chan struct{}
+}
+
+func newBlockingConn(t *testing.T, size int, policy OverflowPolicy) *blockingConn {
+	o, err := newOptions(Tcp, true, []Option{WithCodec(NewRawCodec()), WithWriteQueue(size, policy)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &blockingConn{
+		started: make(chan struct{}, 16),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	c.setup(c, o)
+	return c
+}
+
+func (c *blockingConn) writeFrame(typ MessageType, data []byte) error {
+	c.started <- struct{}{}
+	<-c.release
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, string(data))
+	return nil
+}
+
+func (c *blockingConn) Send(msg []byte) error {
+	return c.send(BinaryMessage, msg)
+}
+
+func (c *blockingConn) Close() error {
+	if c.markClosed() {
+		return c.closeWriter(func() error {
+			close(c.closed)
+			return nil
+		})
+	}
+	return nil
+}
+
+func (c *blockingConn) result() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.written...)
+}
+
+func TestWriteQueueDropNewest(t *testing.T) {
+	c := newBlockingConn(t, 1, OverflowDropNewest)
+	c.Send([]byte("1"))
+	<-c.started
+	if err := c.Send([]byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send([]byte("3")); err != (WriteQueueFullError{}) {
+		t.Fatal(err)
+	}
+	close(c.release)
+	c.Close()
+	<-c.closed
+	if r := c.result(); len(r) != 2 || r[0] != "1" || r[1] != "2" {
+		t.Fatal(r)
+	}
+}
+
+func TestWriteQueueDropOldest(t *testing.T) {
+	c := newBlockingConn(t, 1, OverflowDropOldest)
+	c.Send([]byte("1"))
+	<-c.started
+	dropped := c.SendAsync(&Message{Payload: []byte("2")})
+	last := c.SendAsync(&Message{Payload: []byte("3")})
+	if err := <-dropped; err != (WriteQueueFullError{}) {
+		t.Fatal(err)
+	}
+	close(c.release)
+	if err := <-last; err != nil {
+		t.Fatal(err)
+	}
+	if r := c.result(); len(r) != 2 || r[0] != "1" || r[1] != "3" {
+		t.Fatal(r)
+	}
+}
+
+func TestWriteQueueDisconnect(t *testing.T) {
+	c := newBlockingConn(t, 1, OverflowDisconnect)
+	c.Send([]byte("1"))
+	<-c.started
+	c.Send([]byte("2"))
+	if err := c.Send([]byte("3")); err != (WriteQueueFullError{}) {
+		t.Fatal(err)
+	}
+	if !c.isClosed() || c.CloseReason() != (WriteQueueFullError{}) {
+		t.Fatal("not disconnected", c.CloseReason())
+	}
+	if err := c.Send([]byte("4")); err == nil {
+		t.Fatal("send after disconnect")
+	}
+	close(c.release)
+	<-c.closed
+}
+
+func TestWriteQueueBlock(t *testing.T) {
+	c := newBlockingConn(t, 1, OverflowBlock)
+	c.Send([]byte("1"))
+	<-c.started
+	c.Send([]byte("2"))
+	sent := make(chan error)
+	go func() {
+		sent <- c.Send([]byte("3"))
+	}()
+	select {
+	case <-sent:
+		t.Fatal("send not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(c.release)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	<-c.closed
+	if r := c.result(); len(r) != 3 {
+		t.Fatal(r)
+	}
+}
+
+func TestWriteQueueFlushOnClose(t *testing.T) {
+	c := newBlockingConn(t, 8, OverflowBlock)
+	var results []<-chan error
+	for _, s := range []string{"1", "2", "3"} {
+		results = append(results, c.SendAsync(&Message{Payload: []byte(s)}))
+	}
+	c.Close()
+	if err := <-c.SendAsync(&Message{Payload: []byte("4")}); err == nil {
+		t.Fatal("send after close")
+	}
+	close(c.release)
+	for _, r := range results {
+		if err := <-r; err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-c.closed
+	if r := c.result(); len(r) != 3 || r[2] != "3" {
+		t.Fatal(r)
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	for _, queue := range []int{0, 16} {
+		serverCb := echoCallback()
+		server, err := Listen(WebSocket, 0, serverCb, WithBindAddress("127.0.0.1"), WithWriteQueue(queue, OverflowBlock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb := &testCallback{}
+		client := newTestClient(t, WebSocket, cb, WithWriteQueue(queue, OverflowBlock))
+		if err := client.connect(context.Background(), "ws://"+server.Addr()); err != nil {
+			t.Fatal(err)
+		}
+		if !waitFor(time.Second, func() bool {
+			_, connected, _ := cb.count()
+			return connected == 1
+		}) {
+			t.Fatal("not connected", cb.errors)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					client.SendMessage(&Message{Id: 1, Payload: []byte("concurrent")})
+				}
+			}()
+		}
+		wg.Wait()
+		if !waitFor(2*time.Second, func() bool {
+			messages, _, _ := cb.count()
+			return messages == 200
+		}) {
+			messages, _, _ := cb.count()
+			t.Fatal(queue, "lost messages", messages, cb.errors)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestCloseStopsReadWithWriteQueue(t *testing.T) {
+	serverCb := &testCallback{}
+	server, err := Listen(Tcp, 0, serverCb, WithBindAddress("127.0.0.1"), WithWriteQueue(4, OverflowBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	// 对端只发送不读取, 服务器的写队列无法发送完成
+	peer, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	frame, _ := NewLengthCodec().Encode(&Message{Id: 1, Payload: []byte("hello")})
+	peer.Write(frame)
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := serverCb.count()
+		return messages == 1
+	}) {
+		t.Fatal("not received")
+	}
+	serverCb.Lock()
+	conn := serverCb.connected[0]
+	serverCb.Unlock()
+	go func() {
+		data := make([]byte, 1<<20)
+		for conn.Send(data) == nil {
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if err := server.Kick(conn.Identity(), "stalled"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.State() != ConnStateClosed {
+		t.Fatal("state", conn.State())
+	}
+	for i := 0; i < 5; i++ {
+		peer.Write(frame)
+	}
+	// 读取立即停止, 不等待写队列超时
+	if !waitFor(writeFlushTimeout/2, func() bool {
+		_, _, disconnected := serverCb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("read not stopped")
+	}
+	if messages, _, _ := serverCb.count(); messages != 1 {
+		t.Fatal("messages after close", messages)
+	}
+}
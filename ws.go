@@ -15,164 +15,136 @@
 package net
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
+	"net"
 	"net/http"
-	"strconv"
-	"sync"
 )
 
-type wsServer struct {
-	ws       *websocket.Upgrader
-	callback Callback
-	clients  *sync.Map
+// WebSocket服务器, 可通过Handler挂载到自定义的http路由
+type WebSocketServer interface {
+	Server
+	// 处理WebSocket升级请求的http.Handler, 可挂载到任意路径
+	Handler() http.Handler
 }
 
-func (s *wsServer) wsHttpHandle(w http.ResponseWriter, r *http.Request) {
-	if conn, err := s.ws.Upgrade(w, r, nil); err == nil {
-		c := &wsConn{conn: conn}
-		c.setState(ConnStateConnected)
-		s.clients.Store(c.Identity(), c)
-		if s.callback != nil {
-			s.callback.OnConnected(c)
-		}
-		go s.handleConnection(c, s.callback)
-	} else {
-		s.callback.OnError(err)
-	}
+type wsServer struct {
+	baseServer
+	ws         *websocket.Upgrader
+	httpServer *http.Server
+	// 不监听端口, 仅通过Handler提供服务
+	detached bool
 }
 
-func (s *wsServer) listen(port int, callback Callback) error {
-	s.ws = &websocket.Upgrader{}
-	s.clients = &sync.Map{}
-	s.callback = callback
-	http.HandleFunc("/", s.wsHttpHandle)
-	if err := http.ListenAndServe(":"+strconv.Itoa(port), nil); err != nil {
-		return err
-	}
-	return nil
+func (s *wsServer) Handler() http.Handler {
+	return http.HandlerFunc(s.wsHttpHandle)
 }
 
-func (s *wsServer) Close() error {
-	if s.clients != nil {
-		s.clients.Range(func(key, value interface{}) bool {
-			conn := value.(Conn)
-			if conn != nil {
-				err := conn.Close()
-				if err != nil && s.callback != nil {
-					s.callback.OnError(err)
-				}
-			}
-			return true
-		})
-	}
-	s.ws = nil
-	return nil
-}
-
-func (s *wsServer) handleConnection(conn Conn, callback Callback) {
-	var buf []byte
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
-		}
-	}()
-	for {
-		_, err := conn.read(&buf)
-		if err != nil {
-			conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(conn)
+func (s *wsServer) wsHttpHandle(w http.ResponseWriter, r *http.Request) {
+	if check := s.options.upgradeCheck; check != nil {
+		if err := check(r); err != nil {
+			rejected, ok := err.(UpgradeRejectedError)
+			if !ok {
+				rejected = UpgradeRejectedError{Status: http.StatusForbidden, Reason: err.Error()}
 			}
-			s.clients.Delete(conn.Identity())
-			break
+			http.Error(w, rejected.Reason, rejected.Status)
+			s.report(nil, "upgrade", rejected)
+			return
 		}
-		if callback != nil {
-			callback.OnMessage(conn, buf)
+	}
+	if err := s.acquireConn(r.RemoteAddr); err != nil {
+		reason := "server closing"
+		if rejected, ok := err.(RejectedError); ok {
+			reason = rejected.Reason.String()
 		}
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		s.reject(err)
+		return
+	}
+	if conn, err := s.ws.Upgrade(w, r, nil); err == nil {
+		c := newWsConn(conn, s.options)
+		c.header = r.Header
+		s.serveConn(c, r.RemoteAddr)
+	} else {
+		s.releaseConn(r.RemoteAddr)
+		s.report(nil, "upgrade", err)
 	}
 }
 
-func (s *wsServer) GetConnection(identity uint32) (Conn, bool) {
-	if s.clients == nil {
-		return nil, false
-	} else {
-		if conn, ok := s.clients.Load(identity); ok {
-			return conn.(Conn), ok
+func (s *wsServer) bind(addr string) (net.Addr, error) {
+	s.ws = &websocket.Upgrader{
+		ReadBufferSize:   s.options.readBufferSize,
+		WriteBufferSize:  s.options.writeBufferSize,
+		HandshakeTimeout: s.options.handshakeTimeout,
+		Subprotocols:     s.options.subprotocols,
+		CheckOrigin:      s.options.checkOrigin,
+	}
+	if s.detached {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.options.wsPath, s.Handler())
+	s.httpServer = &http.Server{
+		Handler:   mux,
+		TLSConfig: s.options.tlsConfig,
+	}
+	server := s.httpServer
+	s.goAccept(func() {
+		var err error
+		if server.TLSConfig != nil {
+			// 证书由TLSConfig提供
+			err = server.ServeTLS(listener, "", "")
 		} else {
-			return nil, false
+			err = server.Serve(listener)
 		}
-	}
+		if err != http.ErrServerClosed {
+			s.report(nil, "accept", err)
+		}
+	})
+	return listener.Addr(), nil
 }
 
-type wsClient struct {
-	serverAddr string
-	callback   Callback
-	conn       Conn
+// 关闭http服务器, 等待握手中的连接完成升级
+func (s *wsServer) unbind(ctx context.Context) error {
+	if s.httpServer != nil {
+		return s.httpServer.Shutdown(ctx)
+	}
+	return nil
 }
 
-func (c *wsClient) Reconnect() error {
-	return c.connect(c.serverAddr, c.callback)
+// WebSocket客户端, 可发送文本帧
+type WebSocketClient interface {
+	Client
+	SendText(text string) error
 }
 
-func (c *wsClient) connect(serverAddr string, callback Callback) error {
-	c.serverAddr = serverAddr
-	c.callback = callback
-	conn, _, err := websocket.DefaultDialer.Dial(serverAddr, nil)
-	if err != nil {
-		return err
-	}
-	c.conn = Conn(&wsConn{conn: conn})
-	c.conn.setState(ConnStateConnected)
-	if callback != nil {
-		callback.OnConnected(c.conn)
-	}
-	c.handleConnection(c.conn, callback)
-	return nil
+type wsClient struct {
+	baseClient
 }
 
-func (c *wsClient) handleConnection(conn Conn, callback Callback) {
-	var buf []byte
-	defer func() {
-		if err := conn.Close(); err != nil {
-			if callback != nil {
-				callback.OnError(err)
-			}
-		}
-	}()
-	for {
-		_, err := conn.read(&buf)
-		if err != nil {
-			conn.setState(ConnStateClosed)
-			if callback != nil {
-				callback.OnError(err)
-				callback.OnDisconnected(conn)
-			}
-			c.conn = nil
-			break
-		}
-		if callback != nil {
-			callback.OnMessage(conn, buf)
-		}
-	}
+func (c *wsClient) SendText(text string) error {
+	return c.send(pendingFrame{data: []byte(text), typ: TextMessage})
 }
 
-func (c *wsClient) Send(msg []byte) error {
-	if c.conn != nil && c.conn.State() == ConnStateConnected {
-		return c.conn.Send(msg)
-	} else {
-		return ConnectionError{"Send failed: connection was not built"}
+func (c *wsClient) dial(ctx context.Context, serverAddr string) (Conn, error) {
+	dialer := &websocket.Dialer{
+		NetDialContext:   (&net.Dialer{Timeout: c.options.dialTimeout}).DialContext,
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  c.options.tlsConfig,
+		HandshakeTimeout: c.options.handshakeTimeout,
+		ReadBufferSize:   c.options.readBufferSize,
+		WriteBufferSize:  c.options.writeBufferSize,
+		Subprotocols:     c.options.subprotocols,
 	}
-}
-
-func (c *wsClient) Close() error {
-	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
-		}
-		c.conn = nil
+	conn, _, err := dialer.DialContext(ctx, serverAddr, c.options.requestHeader)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	wc := newWsConn(conn, c.options)
+	wc.header = c.options.requestHeader
+	return wc, nil
 }
@@ -15,50 +15,198 @@
 package net
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
 )
 
+// 控制帧写超时
+const wsControlWriteWait = 5 * time.Second
+
+// 常用的WebSocket关闭码, 见RFC 6455 7.4.1
+const (
+	CloseNormalClosure     = websocket.CloseNormalClosure
+	CloseGoingAway         = websocket.CloseGoingAway
+	CloseProtocolError     = websocket.CloseProtocolError
+	CloseUnsupportedData   = websocket.CloseUnsupportedData
+	CloseNoStatusReceived  = websocket.CloseNoStatusReceived
+	CloseAbnormalClosure   = websocket.CloseAbnormalClosure
+	ClosePolicyViolation   = websocket.ClosePolicyViolation
+	CloseMessageTooBig     = websocket.CloseMessageTooBig
+	CloseInternalServerErr = websocket.CloseInternalServerErr
+)
+
+// WebSocket连接, 可获取握手信息
+type WebSocketConn interface {
+	Conn
+	// 握手请求头, 客户端为发出的请求头
+	RequestHeader() http.Header
+	// 协商确定的子协议, 未协商时为空
+	Subprotocol() string
+	// 发送文本帧
+	SendText(text string) error
+	// 发送关闭帧后关闭连接
+	CloseWithCode(code int, text string) error
+}
+
 type wsConn struct {
 	baseConn
-	conn *websocket.Conn
+	conn      *websocket.Conn
+	header    http.Header
+	readLimit int
 }
 
-func (c *wsConn) Send(msg []byte) error {
-	if c.conn != nil {
-		if msg == nil || len(msg) == 0 {
-			return EmptyMessageError{}
-		}
-		err := c.conn.WriteMessage(websocket.BinaryMessage, msg)
-		if err != nil {
-			return err
+// 关闭帧, 经写队列发送以保证在之前的数据之后到达
+const wsCloseFrame MessageType = -1
+
+func newWsConn(conn *websocket.Conn, o *options) *wsConn {
+	c := &wsConn{conn: conn, readLimit: o.maxMessageSize}
+	c.setup(c, o)
+	if o.maxMessageSize > 0 {
+		// 超过限制时gorilla/websocket回复关闭码CloseMessageTooBig
+		conn.SetReadLimit(int64(o.maxMessageSize))
+	}
+	// 收到心跳控制帧同样视为连接活跃
+	conn.SetPingHandler(func(data string) error {
+		c.touch()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsControlWriteWait))
+		if err == websocket.ErrCloseSent {
+			return nil
 		}
+		return err
+	})
+	conn.SetPongHandler(func(string) error {
+		c.touch()
 		return nil
-	} else {
+	})
+	return c
+}
+
+func (c *wsConn) Send(msg []byte) error {
+	if c.conn == nil {
+		return ConnectionError{"Send failed, connection was not built"}
+	}
+	return c.send(BinaryMessage, msg)
+}
+
+func (c *wsConn) SendText(text string) error {
+	if c.conn == nil {
 		return ConnectionError{"Send failed, connection was not built"}
 	}
+	return c.send(TextMessage, []byte(text))
+}
+
+// 文本消息直接以文本帧发送, 其它消息编码后以二进制帧发送
+func (c *wsConn) SendMessage(msg *Message) error {
+	if c.conn == nil {
+		return ConnectionError{"Send failed, connection was not built"}
+	}
+	typ, data, err := c.frame(msg)
+	if err != nil {
+		return err
+	}
+	return c.send(typ, data)
+}
+
+func (c *wsConn) writeFrame(typ MessageType, data []byte) error {
+	var err error
+	switch typ {
+	case TextMessage:
+		c.prepareWrite()
+		err = c.conn.WriteMessage(websocket.TextMessage, data)
+	case wsCloseFrame:
+		err = c.conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(wsControlWriteWait))
+	default:
+		c.prepareWrite()
+		err = c.conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	return c.timeoutError("write", err)
+}
+
+// 使用WebSocket原生ping帧
+func (c *wsConn) ping() error {
+	if c.conn != nil && !c.isClosed() {
+		return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsControlWriteWait))
+	}
+	return ConnectionError{"Ping failed, connection was not built"}
 }
 
 func (c *wsConn) read(buf *[]byte) (int, error) {
-	if c.conn != nil {
-		t, msg, err := c.conn.ReadMessage()
-		if err == nil && t == websocket.BinaryMessage {
-			*buf = msg
-			return len(msg), nil
+	_, data, err := c.readFrame()
+	if err != nil {
+		return -1, err
+	}
+	*buf = data
+	return len(data), nil
+}
+
+// 读取一帧数据, 对端发送关闭帧时返回CloseError并记录为关闭原因
+func (c *wsConn) readFrame() (MessageType, []byte, error) {
+	if c.conn == nil || c.isClosed() {
+		return BinaryMessage, nil, ConnectionError{"Read failed, connection was closed"}
+	}
+	c.prepareRead()
+	t, data, err := c.conn.ReadMessage()
+	// 关闭后读取到的数据不再处理, 对端的关闭帧仍记录为关闭原因
+	if _, closeFrame := err.(*websocket.CloseError); c.isClosed() && !closeFrame {
+		return BinaryMessage, nil, ConnectionError{"Read failed, connection was closed"}
+	}
+	if err != nil {
+		if ce, ok := err.(*websocket.CloseError); ok {
+			reason := CloseError{Code: ce.Code, Text: ce.Text}
+			c.setCloseReason(reason)
+			return BinaryMessage, nil, reason
 		}
+		if err == websocket.ErrReadLimit {
+			return BinaryMessage, nil, InvalidMessageLengthError{Limit: c.readLimit}
+		}
+		return BinaryMessage, nil, c.timeoutError("read", err)
+	}
+	if t == websocket.TextMessage {
+		return TextMessage, data, nil
 	}
-	return -1, nil
+	return BinaryMessage, data, nil
 }
 
-func (c *wsConn) Close() error {
+func (c *wsConn) setReadDeadline(t time.Time) error {
 	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			return err
-		}
-		c.conn = nil
+		return c.conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *wsConn) setWriteDeadline(t time.Time) error {
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
 	}
 	return nil
 }
 
+func (c *wsConn) Close() error {
+	if c.conn != nil && c.markClosed() {
+		return c.closeWriter(c.conn.Close)
+	}
+	return nil
+}
+
+// 发送关闭帧并关闭连接, 关闭原因记录为CloseError
+func (c *wsConn) CloseWithCode(code int, text string) error {
+	if c.conn == nil || c.isClosed() {
+		return nil
+	}
+	c.setCloseReason(CloseError{Code: code, Text: text})
+	err := c.send(wsCloseFrame, websocket.FormatCloseMessage(code, text))
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
+	return err
+}
+
 func (c *wsConn) RemoteAddr() string {
 	if c.conn != nil {
 		return c.conn.RemoteAddr().String()
@@ -73,6 +221,26 @@ func (c *wsConn) LocalAddr() string {
 	return "0:0:0:0"
 }
 
+func (c *wsConn) PeerCertificates() []*x509.Certificate {
+	if c.conn != nil {
+		if tc, ok := c.conn.UnderlyingConn().(*tls.Conn); ok {
+			return tc.ConnectionState().PeerCertificates
+		}
+	}
+	return nil
+}
+
+func (c *wsConn) RequestHeader() http.Header {
+	return c.header
+}
+
+func (c *wsConn) Subprotocol() string {
+	if c.conn != nil {
+		return c.conn.Subprotocol()
+	}
+	return ""
+}
+
 func (c *wsConn) NetProtocol() Protocol {
 	return WebSocket
 }
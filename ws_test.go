@@ -0,0 +1,338 @@
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func echoCallback() *testCallback {
+	cb := &testCallback{}
+	cb.handler = func(conn Conn, msg *Message) {
+		conn.SendMessage(msg)
+	}
+	return cb
+}
+
+// 连接WebSocket服务器并完成一次消息往返
+func wsRoundTrip(t *testing.T, url string, opts ...Option) {
+	cb := &testCallback{}
+	client := newTestClient(t, WebSocket, cb, opts...)
+	if err := client.connect(context.Background(), url); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 3, Payload: []byte("ws")})
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("no echo")
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.messages[0].Id != 3 || string(cb.messages[0].Payload) != "ws" {
+		t.Fatal(cb.messages[0])
+	}
+}
+
+func TestWsHandlerOnCustomMux(t *testing.T) {
+	serverCb := echoCallback()
+	handler, err := NewWebSocketHandler(serverCb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/game/ws", handler.Handler())
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	wsRoundTrip(t, "ws"+strings.TrimPrefix(httpServer.URL, "http")+"/game/ws")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := handler.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/game/ws", nil); err == nil {
+		t.Fatal("upgrade accepted after shutdown")
+	}
+}
+
+func TestWsServerPath(t *testing.T) {
+	server, err := Listen(WebSocket, 0, echoCallback(), WithBindAddress("127.0.0.1"), WithPath("/ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws://"+server.Addr()+"/", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatal(err, resp)
+	}
+	wsRoundTrip(t, "ws://"+server.Addr()+"/ws")
+}
+
+func TestWss(t *testing.T) {
+	ca := newTestCA(t)
+	server, err := Listen(WebSocket, 0, echoCallback(), WithBindAddress("127.0.0.1"),
+		WithTLSCertificate(ca.issue(t, "server", "127.0.0.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	wsRoundTrip(t, "wss://"+server.Addr(), WithTLSRootCAs(ca.pool))
+}
+
+func newTestWsHandler(t *testing.T, cb Callback, opts ...Option) (*httptest.Server, string) {
+	handler, err := NewWebSocketHandler(cb, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(handler.Handler())
+	return httpServer, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func TestWsCheckOrigin(t *testing.T) {
+	httpServer, url := newTestWsHandler(t, echoCallback())
+	header := http.Header{"Origin": {"http://evil.example.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial(url, header)
+	httpServer.Close()
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatal("cross origin accepted by default", err)
+	}
+
+	httpServer, url = newTestWsHandler(t, echoCallback(), WithCheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "http://game.example.com"
+	}))
+	defer httpServer.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://game.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, _, err := websocket.DefaultDialer.Dial(url, header); err == nil {
+		t.Fatal("origin check ignored")
+	}
+}
+
+func TestWsSubprotocolAndHeader(t *testing.T) {
+	serverCb := echoCallback()
+	httpServer, url := newTestWsHandler(t, serverCb, WithSubprotocols("v2", "v1"))
+	defer httpServer.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, WebSocket, cb, WithSubprotocols("v1", "v2"),
+		WithRequestHeader(http.Header{"X-Client-Version": {"1.0.3"}}))
+	if err := client.connect(context.Background(), url); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := serverCb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	serverCb.Lock()
+	conn := serverCb.connected[0].(WebSocketConn)
+	serverCb.Unlock()
+	if conn.Subprotocol() != "v2" {
+		t.Fatal("subprotocol", conn.Subprotocol())
+	}
+	if conn.RequestHeader().Get("X-Client-Version") != "1.0.3" {
+		t.Fatal("header", conn.RequestHeader())
+	}
+}
+
+func TestWsUpgradeCheck(t *testing.T) {
+	serverCb := echoCallback()
+	httpServer, url := newTestWsHandler(t, serverCb, WithUpgradeCheck(func(r *http.Request) error {
+		if r.URL.Query().Get("token") == "secret" || r.Header.Get("Authorization") == "Bearer secret" {
+			return nil
+		}
+		if r.URL.Query().Get("token") == "" {
+			return UpgradeRejectedError{Status: http.StatusUnauthorized, Reason: "token required"}
+		}
+		return InvalidOptionError{"bad token"}
+	}))
+	defer httpServer.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("missing token accepted", err)
+	}
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?token=wrong", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatal("bad token accepted", err)
+	}
+	serverCb.Lock()
+	if len(serverCb.errors) != 2 {
+		t.Fatal("rejections not reported", serverCb.errors)
+	}
+	serverCb.Unlock()
+	wsRoundTrip(t, url+"?token=secret")
+	wsRoundTrip(t, url, WithRequestHeader(http.Header{"Authorization": {"Bearer secret"}}))
+}
+
+func TestWsTextFrames(t *testing.T) {
+	serverCb := &testCallback{}
+	serverCb.handler = func(conn Conn, msg *Message) {
+		if msg.Type == TextMessage {
+			conn.(WebSocketConn).SendText("echo:" + string(msg.Payload))
+		} else {
+			conn.SendMessage(msg)
+		}
+	}
+	httpServer, url := newTestWsHandler(t, serverCb)
+	defer httpServer.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, WebSocket, cb, WithSendQueue(4))
+	if err := client.(WebSocketClient).SendText(`{"op":"queued"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.connect(context.Background(), url); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 1
+	}) {
+		t.Fatal("queued text not echoed", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 5, Payload: []byte("binary")})
+	client.SendMessage(&Message{Payload: []byte(`{"op":"direct"}`), Type: TextMessage})
+	if !waitFor(time.Second, func() bool {
+		messages, _, _ := cb.count()
+		return messages == 3
+	}) {
+		t.Fatal("no echo", cb.errors)
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.messages[0].Type != TextMessage || string(cb.messages[0].Payload) != `echo:{"op":"queued"}` {
+		t.Fatal(cb.messages[0])
+	}
+	if cb.messages[1].Type != BinaryMessage || cb.messages[1].Id != 5 || string(cb.messages[1].Payload) != "binary" {
+		t.Fatal(cb.messages[1])
+	}
+	if cb.messages[2].Type != TextMessage || string(cb.messages[2].Payload) != `echo:{"op":"direct"}` {
+		t.Fatal(cb.messages[2])
+	}
+}
+
+func TestWsCloseCode(t *testing.T) {
+	serverCb := &testCallback{}
+	serverCb.handler = func(conn Conn, msg *Message) {
+		conn.(WebSocketConn).CloseWithCode(ClosePolicyViolation, "banned")
+	}
+	httpServer, url := newTestWsHandler(t, serverCb)
+	defer httpServer.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, WebSocket, cb)
+	if err := client.connect(context.Background(), url); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 1, Payload: []byte("hi")})
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := cb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("not disconnected")
+	}
+	cb.Lock()
+	reason := cb.disconnected[0].CloseReason()
+	cb.Unlock()
+	if ce, ok := reason.(CloseError); !ok || ce.Code != ClosePolicyViolation || ce.Text != "banned" {
+		t.Fatal("client close reason", reason)
+	}
+	serverCb.Lock()
+	defer serverCb.Unlock()
+	if ce, ok := serverCb.connected[0].CloseReason().(CloseError); !ok || ce.Code != ClosePolicyViolation {
+		t.Fatal("server close reason", serverCb.connected[0].CloseReason())
+	}
+}
+
+func TestWsPeerCloseCode(t *testing.T) {
+	serverCb := echoCallback()
+	httpServer, url := newTestWsHandler(t, serverCb)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseGoingAway, "bye"))
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := serverCb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("not disconnected")
+	}
+	serverCb.Lock()
+	defer serverCb.Unlock()
+	if len(serverCb.errors) != 1 || UnwrapConnError(serverCb.errors[0]) != (CloseError{Code: CloseGoingAway, Text: "bye"}) {
+		t.Fatal(serverCb.errors)
+	}
+}
+
+func TestWsMaxMessageSize(t *testing.T) {
+	serverCb := echoCallback()
+	httpServer, url := newTestWsHandler(t, serverCb, WithMaxMessageSize(64))
+	defer httpServer.Close()
+
+	cb := &testCallback{}
+	client := newTestClient(t, WebSocket, cb)
+	if err := client.connect(context.Background(), url); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !waitFor(time.Second, func() bool {
+		_, connected, _ := cb.count()
+		return connected == 1
+	}) {
+		t.Fatal("not connected", cb.errors)
+	}
+	client.SendMessage(&Message{Id: 1, Payload: make([]byte, 1024)})
+	if err := firstError(t, serverCb, time.Second); err != (InvalidMessageLengthError{Limit: 64}) {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool {
+		_, _, disconnected := cb.count()
+		return disconnected == 1
+	}) {
+		t.Fatal("client not disconnected")
+	}
+}